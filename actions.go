@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of --on-match actions.
+const actionRun = "run"
+const actionPost = "post"
+const actionBell = "bell"
+const actionNotify = "notify"
+
+// DefaultCooldown is the minimum time between two runs of the same --on-match action.
+const DefaultCooldown = "10s"
+
+// DefaultMaxActions is the most --on-match actions started per minute.
+const DefaultMaxActions = 10
+
+// Most --on-match actions running at the same time. Matches arriving while that many are running are skipped.
+const maxRunningActions = 4
+
+// Characters that can't be used in the names of environment variables.
+var envNameRegex = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// Client used to POST matches to webhooks.
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// matchRule is an --on-match rule: a --where condition and the action run when a message matches it.
+type matchRule struct {
+	condition  string
+	cond       whereExpr
+	kind       string
+	target     string
+	last       time.Time
+	suppressed int
+}
+
+// matchActions runs the actions of the --on-match rules, limited by a cool-down per rule and a number of actions per
+// minute.
+type matchActions struct {
+	rules     []*matchRule
+	cooldown  time.Duration
+	perMinute int
+	started   []time.Time
+	running   chan struct{}
+	since     time.Time
+}
+
+func newMatchActions(specs []string, cooldown time.Duration, perMinute int) (*matchActions, error) {
+	actions := &matchActions{
+		cooldown:  cooldown,
+		perMinute: perMinute,
+		running:   make(chan struct{}, maxRunningActions),
+		since:     time.Now(),
+	}
+	for _, spec := range specs {
+		rule, err := parseMatchRule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %s", spec, err.Error())
+		}
+		actions.rules = append(actions.rules, rule)
+	}
+	return actions, nil
+}
+
+// Parse a rule, e.g., 'loglevel == "ERROR" => run notify.sh', 'http_status >= 500 => post https://hooks.example.com/x',
+// 'exists panic => bell' or 'source == "db1" => notify'.
+func parseMatchRule(spec string) (*matchRule, error) {
+	parts := strings.SplitN(spec, "=>", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("expected <condition> => <action>")
+	}
+	rule := &matchRule{condition: strings.TrimSpace(parts[0])}
+	cond, err := parseWhere(rule.condition)
+	if err != nil {
+		return nil, err
+	}
+	rule.cond = cond
+
+	action := strings.Fields(parts[1])
+	if len(action) == 0 {
+		return nil, fmt.Errorf("no action given, use run <command>, post <url>, bell or notify")
+	}
+	rule.kind = action[0]
+	rule.target = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(parts[1]), rule.kind))
+	switch rule.kind {
+	case actionRun:
+		if len(rule.target) == 0 {
+			return nil, fmt.Errorf("run needs a command")
+		}
+	case actionPost:
+		if len(rule.target) == 0 {
+			return nil, fmt.Errorf("post needs a URL")
+		}
+	case actionBell, actionNotify:
+		if len(rule.target) > 0 {
+			return nil, fmt.Errorf("%s doesn't take arguments", rule.kind)
+		}
+	default:
+		return nil, fmt.Errorf("unknown action '%s', use run <command>, post <url>, bell or notify", rule.kind)
+	}
+	return rule, nil
+}
+
+// Run the actions of the rules matched by new messages. The messages logged before the tail started, e.g., those of
+// the first search over the whole range, don't run actions. The messages must already be adjusted.
+func (a *matchActions) handle(opts *options, messages []logMessage) {
+	now := time.Now()
+	for _, msg := range filterWhere(opts.where, messages) {
+		if msg.timestamp.Before(a.since) {
+			continue
+		}
+		for _, rule := range a.rules {
+			if !rule.cond.eval(msg.fields) {
+				continue
+			}
+			// Take a slot first, so a match skipped because too many actions are running doesn't start the
+			// cool-down or count for the limit
+			select {
+			case a.running <- struct{}{}:
+			default:
+				rule.suppressed++
+				continue
+			}
+			suppressed, ok := a.allow(rule, now)
+			if !ok {
+				<-a.running
+				continue
+			}
+			go func(rule *matchRule, msg logMessage, suppressed int) {
+				defer func() { <-a.running }()
+				if err := rule.execute(msg, suppressed); err != nil {
+					fmt.Fprintf(os.Stderr, "The --on-match action '%s %s' failed: %s\n", rule.kind, rule.target,
+						err.Error())
+				}
+			}(rule, msg, suppressed)
+		}
+	}
+}
+
+// Decide whether a rule runs its action for a match. During the cool-down of the rule, or once the actions of the last
+// minute reached the limit, the match is only counted.
+// returns: the number of matches skipped since the last run, and whether the action runs.
+func (a *matchActions) allow(rule *matchRule, now time.Time) (int, bool) {
+	i := sort.Search(len(a.started), func(i int) bool {
+		return now.Sub(a.started[i]) < time.Minute
+	})
+	a.started = a.started[i:]
+
+	if (!rule.last.IsZero() && now.Sub(rule.last) < a.cooldown) || len(a.started) >= a.perMinute {
+		rule.suppressed++
+		return 0, false
+	}
+	rule.last = now
+	a.started = append(a.started, now)
+	suppressed := rule.suppressed
+	rule.suppressed = 0
+	return suppressed, true
+}
+
+// Run the action of a rule for a message.
+func (rule *matchRule) execute(msg logMessage, suppressed int) error {
+	data := templateData(msg)
+	switch rule.kind {
+	case actionRun:
+		payload, _ := json.Marshal(data)
+		cmd := exec.Command("sh", "-c", rule.target)
+		cmd.Env = append(os.Environ(), actionEnv(msg, suppressed)...)
+		cmd.Stdin = bytes.NewReader(payload)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	case actionPost:
+		body, _ := json.Marshal(map[string]interface{}{
+			"condition":  rule.condition,
+			"suppressed": suppressed,
+			"message":    data,
+		})
+		resp, err := webhookClient.Post(rule.target, jsonAcceptType, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		//noinspection GoUnhandledErrorResult
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("the webhook answered '%s'", resp.Status)
+		}
+	case actionBell:
+		fmt.Fprint(os.Stderr, "\a")
+	case actionNotify:
+		text := msg.fields[messageTextField]
+		if len(text) == 0 {
+			text = msg.fields[messageField]
+		}
+		if source := msg.fields[sourceField]; len(source) > 0 {
+			text = source + ": " + text
+		}
+		if suppressed > 0 {
+			text += fmt.Sprintf(" (and %d more)", suppressed)
+		}
+		return notifyCommand("graylog: "+rule.condition, plainText(text)).Run()
+	}
+	return nil
+}
+
+// The environment variables of a run action: GRAYLOG_<FIELD> for every field of the message, e.g., GRAYLOG_SOURCE,
+// and GRAYLOG_SUPPRESSED, the number of matches skipped since the last run.
+func actionEnv(msg logMessage, suppressed int) []string {
+	env := []string{"GRAYLOG_SUPPRESSED=" + strconv.Itoa(suppressed)}
+	for name, value := range msg.fields {
+		env = append(env, "GRAYLOG_"+envNameRegex.ReplaceAllString(strings.ToUpper(name), "_")+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
@@ -19,7 +19,7 @@ const jsonAcceptType = "application/json"
 const csvAcceptType = "text/csv"
 
 const graylogOutputTimeFormat = "2006-01-02T15:04:05.000Z"
-const graylogInputTimeFormat = "2006-01-02 15:04:05"
+const graylogInputTimeFormat = "2006-01-02 15:04:05.000"
 
 const relativeSearch = "search/universal/relative?range=%s"
 const absoluteSearch = "search/universal/absolute?from=%s&to=%s"
@@ -32,46 +32,34 @@ var msgCache, _ = lru.New(1024)
 // Store the stream information so we don't have to pull it repeatedly.
 var streamCache map[string]map[string]string
 
-// Simple structure to hold a single log message.
+// Simple structure to hold a single log message. The values keep the types (numbers, booleans, nested objects and
+// arrays) of the message from Graylog, fields is the flattened string view of the same values plus helper fields.
 type logMessage struct {
 	id        string
 	timestamp time.Time
 	streams   []string
 	fields    map[string]string
+	values    map[string]interface{}
 }
 
 // Fetch all messages that match the settings in the options.
 func fetchMessages(opts *options) (result []logMessage) {
+	if len(opts.archivePath) > 0 {
+		return fetchArchivedMessages(opts)
+	}
+
 	api, export := messageAPIURI(opts)
 	if export {
-		callGraylog(opts, api, csvAcceptType)
+		if opts.exportChunk > 0 || opts.resume {
+			exportChunked(opts)
+		} else if opts.exportFormat == csvFormat && len(opts.where) == 0 {
+			callGraylog(opts, api, csvAcceptType)
+		} else {
+			exportMessages(opts)
+		}
 	} else {
 		jsonBytes := callGraylog(opts, api, jsonAcceptType)
-		messages := getJSONArray(jsonBytes, "messages")
-		_, _ = jsonparser.ArrayEach(messages, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
-			msg := getJSONSimpleMap(value, "message")
-			tsStr := msg[timestampField]
-			// Mon Jan 2 15:04:05 -0700 MST 2006
-
-			ts, err := time.Parse(graylogOutputTimeFormat, tsStr)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Invalid json timestamp: %s - %s\n", tsStr, err.Error())
-			}
-			if err == nil {
-				streams := getJSONArrayOfStrings(value, "message", "streams")
-
-				msgObj := logMessage{
-					id:        string(msg["_id"]),
-					timestamp: ts,
-					streams:   streams,
-					fields:    msg,
-				}
-				result = append(result, msgObj)
-			}
-		})
-		sort.Slice(result, func(i, j int) bool {
-			return result[i].timestamp.Before(result[j].timestamp)
-		})
+		result = parseMessages(jsonBytes)
 
 		if opts.limit > 0 {
 			var filteredMessages []logMessage
@@ -88,18 +76,69 @@ func fetchMessages(opts *options) (result []logMessage) {
 	return result
 }
 
+// Parse the messages in a search response, sorted oldest first.
+func parseMessages(jsonBytes []byte) (result []logMessage) {
+	messages, err := decodeMessages(jsonBytes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read messages: %s\n", err.Error())
+		return nil
+	}
+	for _, values := range messages {
+		msg := newLogMessage(values)
+		tsStr := msg.fields[timestampField]
+		// Mon Jan 2 15:04:05 -0700 MST 2006
+
+		ts, err := time.Parse(graylogOutputTimeFormat, tsStr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid json timestamp: %s - %s\n", tsStr, err.Error())
+		}
+		if err == nil {
+			msg.timestamp = ts
+			result = append(result, msg)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].timestamp.Before(result[j].timestamp)
+	})
+	return result
+}
+
+// Create a log message from its decoded values.
+func newLogMessage(values map[string]interface{}) logMessage {
+	fields := flattenValues(values)
+
+	var streams []string
+	if list, ok := values["streams"].([]interface{}); ok {
+		for _, v := range list {
+			if id, ok := v.(string); ok {
+				streams = append(streams, id)
+			}
+		}
+	}
+
+	return logMessage{
+		id:      fields["_id"],
+		streams: streams,
+		fields:  fields,
+		values:  values,
+	}
+}
+
 // Compute the API Uri to call. Determined by examing the command-line options.
 func messageAPIURI(opts *options) (uri string, export bool) {
 	if opts.startDate == nil || opts.endDate == nil {
 		uri = fmt.Sprintf(relativeSearch, strconv.Itoa(opts.timeRange))
 	} else {
+		// Graylog reads times without a zone as UTC
 		uri = fmt.Sprintf(absoluteSearch,
-			url.QueryEscape((*opts.startDate).Format(graylogInputTimeFormat)),
-			url.QueryEscape((*opts.endDate).Format(graylogInputTimeFormat)),
+			url.QueryEscape((*opts.startDate).UTC().Format(graylogInputTimeFormat)),
+			url.QueryEscape((*opts.endDate).UTC().Format(graylogInputTimeFormat)),
 		)
 		if len(opts.fields) > 0 {
 			export = true
-			uri += "&fields=" + url.QueryEscape(opts.fields)
+			if opts.exportFormat == csvFormat {
+				uri += "&fields=" + url.QueryEscape(opts.fields)
+			}
 		}
 	}
 	if opts.limit > 0 && !export {
@@ -127,6 +166,10 @@ func messageAPIURI(opts *options) (uri string, export bool) {
 
 // Fetch the list of streams defined in Graylog.
 func fetchStreams(opts *options) map[string]map[string]string {
+	if len(opts.archivePath) > 0 {
+		loadArchiveOnce(opts)
+		return archiveStreams
+	}
 	if len(streamCache) > 0 {
 		return streamCache
 	}
@@ -171,7 +214,7 @@ func callGraylog(opts *options, api string, acceptType string) []byte {
 	}
 
 	if acceptType == csvAcceptType {
-		readCSV(uri+"/"+api, username, password, ignoreCert)
+		readCSV(opts, uri+"/"+api, username, password, ignoreCert)
 		return nil
 	}
 	return nil
@@ -182,21 +225,59 @@ func readBytes(uri string, username string, password string, ignoreCert bool) []
 	return fetch(uri, username, password, ignoreCert, jsonAcceptType)
 }
 
-// Process the results from Graylog as a CSV file.
-func readCSV(uri string, username string, password string, ignoreCert bool) {
-	fmt.Println("Exporting...")
-	body := fetch(uri, username, password, ignoreCert, csvAcceptType)
+// Process the results from Graylog as a CSV file, streaming it to the export destination.
+func readCSV(opts *options, uri string, username string, password string, ignoreCert bool) {
+	fmt.Fprintln(os.Stderr, "Exporting...")
+	resp := connect(uri, username, password, ignoreCert, csvAcceptType)
+	//noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
 
-	if err := ioutil.WriteFile("export.csv", body, 0644); err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to write to file 'export.csv': %s", err.Error())
-	} else {
-		cwd, _ := os.Getwd()
-		fmt.Println("Contents exported to " + cwd + "/export.csv")
-	}
+	exportToFile(opts, resp.Body)
 }
 
 // Low-level HTTP call to Graylog.
 func fetch(uri string, username string, password string, ignoreCert bool, acceptType string) []byte {
+	resp := connect(uri, username, password, ignoreCert, acceptType)
+	//noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read content from Graylog: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	return body
+}
+
+// Open an HTTP connection to Graylog. The caller is responsible for closing the response body.
+func connect(uri string, username string, password string, ignoreCert bool, acceptType string) *http.Response {
+	resp, err := openConnection(uri, username, password, ignoreCert, acceptType)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+	return resp
+}
+
+// Call Graylog, returning an error instead of exiting when the call fails or Graylog doesn't answer with 200 OK.
+func tryCallGraylog(opts *options, api string) ([]byte, error) {
+	cfg := opts.serverConfig
+	resp, err := openConnection(cfg.Uri()+"/"+api, cfg.Username(), cfg.Password(), cfg.IgnoreCert(), jsonAcceptType)
+	if err != nil {
+		return nil, err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Graylog answered '%s' for %s", resp.Status, api)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Open an HTTP connection to Graylog.
+func openConnection(uri string, username string, password string, ignoreCert bool, acceptType string) (*http.Response, error) {
 	var client *http.Client
 	if ignoreCert {
 		tr := &http.Transport{
@@ -209,8 +290,7 @@ func fetch(uri string, username string, password string, ignoreCert bool, accept
 
 	req, err := http.NewRequest("GET", uri, nil)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Request is malformed: %s\n", err.Error())
-		os.Exit(1)
+		return nil, fmt.Errorf("Request is malformed: %s", err.Error())
 	}
 	if len(username) > 0 && len(password) > 0 {
 		req.SetBasicAuth(username, password)
@@ -218,17 +298,8 @@ func fetch(uri string, username string, password string, ignoreCert bool, accept
 	req.Header.Add("Accept", acceptType)
 	resp, err := client.Do(req)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to Graylog: %s\n", err.Error())
-		os.Exit(1)
-	}
-	//noinspection GoUnhandledErrorResult
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to read content from Graylog: %s\n", err.Error())
-		os.Exit(1)
+		return nil, fmt.Errorf("Unable to connect to Graylog: %s", err.Error())
 	}
 
-	return body
+	return resp, nil
 }
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A single message in an archive file. Archives hold one record per line.
+type archiveRecord struct {
+	ID           string                 `json:"id"`
+	Timestamp    time.Time              `json:"timestamp"`
+	Streams      []string               `json:"streams"`
+	Fields       map[string]string      `json:"fields"`
+	Values       map[string]interface{} `json:"values,omitempty"`
+	StreamTitles map[string]string      `json:"stream_titles,omitempty"`
+}
+
+// The contents of the archive used by --from-file, loaded on first use.
+var archiveMessages []logMessage
+var archiveStreams map[string]map[string]string
+
+// Append the messages to an archive file, along with the titles of their streams so they can be displayed offline.
+// Files ending in '.gz' get a gzip member per save.
+func saveArchive(path string, messages []logMessage, streams map[string]map[string]string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = f
+	var zw *gzip.Writer
+	if strings.HasSuffix(strings.ToLower(path), ".gz") {
+		zw = gzip.NewWriter(f)
+		w = zw
+	}
+
+	buf := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buf)
+	for _, msg := range messages {
+		record := archiveRecord{
+			ID:           msg.id,
+			Timestamp:    msg.timestamp,
+			Streams:      msg.streams,
+			Fields:       msg.fields,
+			Values:       msg.values,
+			StreamTitles: make(map[string]string),
+		}
+		for _, id := range msg.streams {
+			if stream, ok := streams[id]; ok {
+				record.StreamTitles[id] = stream["title"]
+			}
+		}
+		if err = encoder.Encode(&record); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = buf.Flush()
+	}
+	if zw != nil {
+		if err2 := zw.Close(); err == nil {
+			err = err2
+		}
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Read the messages and stream titles from an archive file. Messages saved more than once are only returned once.
+func loadArchive(path string) (messages []logMessage, streams map[string]map[string]string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(strings.ToLower(path), ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		r = zr
+	}
+
+	streams = make(map[string]map[string]string)
+	seen := make(map[string]bool)
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	for {
+		var record archiveRecord
+		if err = decoder.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("%s is not a valid archive: %s", path, err.Error())
+		}
+		for id, title := range record.StreamTitles {
+			streams[id] = map[string]string{"id": id, "title": title}
+		}
+		if seen[record.ID] {
+			continue
+		}
+		seen[record.ID] = true
+		if record.Fields == nil {
+			record.Fields = make(map[string]string)
+		}
+		messages = append(messages, logMessage{
+			id:        record.ID,
+			timestamp: record.Timestamp,
+			streams:   record.Streams,
+			fields:    record.Fields,
+			values:    record.Values,
+		})
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].timestamp.Before(messages[j].timestamp)
+	})
+	return messages, streams, nil
+}
+
+// Load the --from-file archive, once.
+func loadArchiveOnce(opts *options) {
+	if archiveStreams != nil {
+		return
+	}
+	var err error
+	archiveMessages, archiveStreams, err = loadArchive(opts.archivePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read archive '%s': %s\n", opts.archivePath, err.Error())
+		os.Exit(1)
+	}
+}
+
+// Select the archived messages that match the --start/--end window and the streams. The query can't be evaluated
+// offline. Like a search, only the latest --limit messages are returned.
+func fetchArchivedMessages(opts *options) (result []logMessage) {
+	loadArchiveOnce(opts)
+
+	for _, msg := range archiveMessages {
+		if opts.startDate != nil && msg.timestamp.Before(*opts.startDate) {
+			continue
+		}
+		if opts.endDate != nil && msg.timestamp.After(*opts.endDate) {
+			continue
+		}
+		if len(opts.streamIds) > 0 && !containsAny(msg.streams, opts.streamIds) {
+			continue
+		}
+		// The fields and values are adjusted when displayed, so each search gets its own copy
+		result = append(result, copyMessage(msg))
+	}
+
+	if opts.limit > 0 && len(result) > opts.limit {
+		result = result[len(result)-opts.limit:]
+	}
+	return result
+}
+
+// Check whether any of the values are in the list.
+func containsAny(list []string, values []string) bool {
+	for _, item := range list {
+		for _, v := range values {
+			if item == v {
+				return true
+			}
+		}
+	}
+	return false
+}
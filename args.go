@@ -4,12 +4,10 @@ import (
 	"./config"
 	"fmt"
 	"github.com/akamensky/argparse"
-	"github.com/araddon/dateparse"
 	"golang.org/x/sys/unix"
 	"os"
 	"os/user"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -21,100 +19,399 @@ const DefaultLimit = 300
 // DefaultRange is the value used when no range is provided by the user
 const DefaultRange = "2h"
 
+// DefaultWindow is the half-width of the --around window when no --window is provided by the user
+const DefaultWindow = "5m"
+
 // DefaultConfigPath is the default location of the configuration path.
 const DefaultConfigPath = "~/.graylog"
 
+// Commands that can be given as the first arguments.
+const saveCommand = "save"
+const runCommand = "run"
+const searchesListCommand = "searches list"
+const searchesSaveCommand = "searches save"
+const contextCommand = "context"
+const showCommand = "show"
+const uiCommand = "ui"
+
+// The positional arguments of each command. Without a command, messages are searched (or tailed). A final argument
+// ending in '...' takes any number of values, including none.
+var commandArguments = map[string][]string{
+	saveCommand:         {"archive-file"},
+	runCommand:          {"name", "args..."},
+	searchesListCommand: {},
+	searchesSaveCommand: {"name"},
+	contextCommand:      {"message-id"},
+	showCommand:         {"message-id"},
+	uiCommand:           {},
+}
+
+// Descriptions of the commands, shown in the usage message.
+const commandsHelp = `Search and tail logs from Graylog.
+
+Commands:
+  save <archive-file>    Save the messages found by the search into a local archive file, to be searched later
+                         with --from-file
+  run <name> [args...]   Run a saved search from the [searches.<name>] config section, filling in its {{.arg1}},
+                         {{.arg2}}, ... placeholders, or a search saved in Graylog. Other flags override the saved
+                         settings
+  searches list          List the saved searches of the config file and of Graylog
+  searches save <name>   Save the -q, -s, -r, --format and --columns flags as a named search in the config file
+  context <message-id>   Show the messages logged just before and after a message (given as <id> or <index>/<id>)
+                         by the same source, see --before, --after, --same and --window
+  show <message-id>      Show a message with its index, streams and all its fields with their types. With --json,
+                         prints the untouched message returned by Graylog
+  ui                     Browse the messages in a full-screen terminal ui, starting from the -q, -s and -r flags.
+                         With --tail, starts tailing`
+
+// Values accepted by the --color option.
+const colorAuto = "auto"
+const colorAlways = "always"
+const colorNever = "never"
+
 // options structure stores the command-line options and values.
 type options struct {
-	listStreams  bool
-	application  string
-	query        string
-	fields       string
-	limit        int
-	streamIds    []string
-	tail         bool
-	configPath   string
-	timeRange    int
-	startDate    *time.Time
-	endDate      *time.Time
-	json         bool
-	serverConfig *config.IniFile
-	noColor      bool
+	command       string
+	commandArgs   []string
+	archivePath   string
+	listStreams   bool
+	application   string
+	query         string
+	fields        string
+	limit         int
+	streamIds     []string
+	tail          bool
+	tailQueries   []*tailQuery
+	actions       *matchActions
+	stats         bool
+	statsOnly     bool
+	configPath    string
+	timeRange     int
+	startDate     *time.Time
+	endDate       *time.Time
+	json          bool
+	serverConfig  *config.IniFile
+	useColors     bool
+	colors        *colorScheme
+	spinner       bool
+	pager         bool
+	location      *time.Location
+	timeFormat    string
+	traces        *traceFolding
+	extractions   []extractionRule
+	extractors    []fieldExtractor
+	where         []whereExpr
+	contextBefore int
+	contextAfter  int
+	contextSame   string
+	contextWindow time.Duration
+	formats       []config.FormatDefinition
+	columns       []string
+	search        config.SavedSearch
+	exportPath    string
+	exportFormat  string
+	exportChunk   int
+	parallel      int
+	resume        bool
+	record        bool
+	rotateSize    int64
+	force         bool
 }
 
 // parseArgs parses the command-line arguments.
 // returns: *options which contains both the parsed command-line arguments.
 func parseArgs() *options {
-	parser := argparse.NewParser("graylog", "Search and tail logs from Graylog.")
+	parser := argparse.NewParser("graylog", commandsHelp)
 
 	var defaultConfigPath = expandPath(DefaultConfigPath)
 
 	listStreams := parser.Flag("", "list-streams", &argparse.Options{Required: false, Help: "List Graylog streams and exit."})
-	application := parser.String("a", "application", &argparse.Options{Required: false, Help: "Special case to search the 'application' message field, e.g., -a send-email is equivalent to -q 'application:send-email'. Merged with the -q query using 'AND' if the -q query is present."})
+	application := parser.String("a", "application", &argparse.Options{Required: false, Help: "Special case to search the 'application' message field, e.g., -a send-email is equivalent to -q 'application:\"send-email\"'. Merged with the -q query using 'AND' if the -q query is present."})
 	query := parser.String("q", "query", &argparse.Options{Required: false, Help: "Query terms to search on (Elasticsearch syntax). Defaults to '*'."})
-	fields := parser.String("e", "export", &argparse.Options{Required: false, Help: "Export specified fields as CSV into a file named 'export.csv'. Format is 'field1,field2,field3...'. Requires --start (and, optionally, --end) option."})
+	fieldTerms := parser.StringList("", "field", &argparse.Options{Required: false, Help: "Only messages whose field has the value, e.g., --field http_method=POST. Values may contain * and ? wildcards. Can be repeated; repeats of the same field match any of the values. Merged with the -q query using 'AND'."})
+	notTerms := parser.StringList("", "not", &argparse.Options{Required: false, Help: "Exclude messages whose field has the value, e.g., --not source=healthcheck. Can be repeated."})
+	level := parser.String("", "level", &argparse.Options{Required: false, Help: "Only messages of a log level, or a range of levels, e.g., ERROR, '>=WARN' or '<INFO'. Matches level names in the 'loglevel' field and syslog severities in the 'level' field."})
+	sources := parser.StringList("", "source", &argparse.Options{Required: false, Help: "Only messages from the source. Can be repeated to match any of the sources."})
+	exists := parser.StringList("", "exists", &argparse.Options{Required: false, Help: "Only messages that have the field. Can be repeated."})
+	showQuery := parser.Flag("", "show-query", &argparse.Options{Required: false, Help: "Print the final query and search URL on stderr."})
+	fields := parser.String("e", "export", &argparse.Options{Required: false, Help: "Export specified fields as CSV into the --out file. Format is 'field1,field2,field3...'. Uses the --start/--end or --range time window. With --tail, the fields of new messages are continuously appended to the --out file instead."})
+	exportPath := parser.String("o", "out", &argparse.Options{Required: false, Help: "File to export to, or '-' for stdout. CSV and NDJSON files ending in '.gz' are gzip compressed.", Default: DefaultExportPath})
+	exportFormat := parser.Selector("", "export-format", []string{csvFormat, ndjsonFormat, sqliteFormat, parquetFormat}, &argparse.Options{Required: false, Help: "Format of the --export file. CSV is produced by Graylog, the others are written while paging through the search results. Default: based on the --out file extension (.ndjson/.jsonl, .sqlite/.db, .parquet), otherwise csv."})
+	chunk := parser.String("", "chunk", &argparse.Options{Required: false, Help: "Split the --export time range into chunks of this size, e.g., 1h, and fetch them one at a time. Progress is recorded in '<out>.checkpoint'."})
+	parallel := parser.Int("", "parallel", &argparse.Options{Required: false, Help: "The number of --chunk exports to fetch at the same time. Chunks are still written in order.", Default: 1})
+	resume := parser.Flag("", "resume", &argparse.Options{Required: false, Help: "Resume an interrupted --chunk export after its last completed chunk."})
+	rotateSize := parser.String("", "rotate-size", &argparse.Options{Required: false, Help: "Size at which the file recorded by --tail --export is rotated, e.g., 500KB, 100MB or 1GB. 0 disables rotation.", Default: DefaultRotateSize})
+	force := parser.Flag("f", "force", &argparse.Options{Required: false, Help: "Overwrite the --out file if it already exists."})
 	limit := parser.Int("l", "limit", &argparse.Options{Required: false, Help: "The maximum number of messages to request from Graylog. Must be greater then 0", Default: DefaultLimit})
 	streamNames := parser.String("s", "stream", &argparse.Options{Required: false, Help: "The name of the stream(s) to display messages from. Default: all streams."})
 	tail := parser.Flag("t", "tail", &argparse.Options{Required: false, Help: "Whether to tail the output. Requires a relative search."})
+	stats := parser.Flag("", "stats", &argparse.Options{Required: false, Help: "While tailing, show a panel on stderr with the rate of messages and the counts by level and by source over the last minutes, updated live."})
+	tailQueries := parser.StringList("", "tail-query", &argparse.Options{Required: false, Help: "Tail several queries at once, each given as <name>=<query>, e.g., --tail-query 'errors=level:3' --tail-query 'slow=took_ms:>1000'. Lines are prefixed by the name of their query, messages found by more than one query are shown once. The -q query and other flags apply to every query. Implies --tail."})
+	tailSearches := parser.StringList("", "tail-search", &argparse.Options{Required: false, Help: "Tail a saved search along with the --tail-query queries, using its query, streams, range, format and columns. Can be repeated. Implies --tail."})
+	onMatch := parser.StringList("", "on-match", &argparse.Options{Required: false, Help: "While tailing, run an action when a message matches a --where condition, given as '<condition> => <action>'. Actions: 'run <command>' runs a shell command with the fields in GRAYLOG_<FIELD> environment variables and the message as JSON on stdin, 'post <url>' posts the message as JSON to a webhook, 'bell' rings the terminal bell and 'notify' shows a desktop notification. Can be repeated."})
+	cooldown := parser.String("", "cooldown", &argparse.Options{Required: false, Help: "Minimum time between two runs of the same --on-match action. Matches in between are skipped and counted in GRAYLOG_SUPPRESSED. Default: " + DefaultCooldown})
+	maxActions := parser.Int("", "max-actions", &argparse.Options{Required: false, Help: "The most --on-match actions started per minute, across all rules. At most " + strconv.Itoa(maxRunningActions) + " run at the same time.", Default: DefaultMaxActions})
+	statsOnly := parser.Flag("", "stats-only", &argparse.Options{Required: false, Help: "Like --stats, but only show the panel, not the messages."})
 	configPath := parser.String("c", "config", &argparse.Options{Required: false, Help: "Path to the config file", Default: defaultConfigPath})
-	timeRange := parser.String("r", "range", &argparse.Options{Required: false, Help: "Time range to search backwards from the current moment. Examples: 30m, 2h, 4d", Default: DefaultRange})
-	start := parser.String("", "start", &argparse.Options{Required: false, Help: "Starting time to search from. Allows variable formats, including '1:32pm' or '1/4/2019 12:30:00'."})
-	end := parser.String("", "end", &argparse.Options{Required: false, Help: "Ending time to search from. Allows variable formats, including '6:45am' or '2019-01-04 12:30:00'. Defaults to now if --start is provided but no --end."})
-	json := parser.Flag("j", "json", &argparse.Options{Required: false, Help: "Output messages in json format. Shows the modified log message, not the untouched message from Graylog. Useful in understanding the fields available when creating Format templates or for further processing."})
-	noColor := parser.Flag("", "no-colors", &argparse.Options{Required: false, Help: "Don't use colors in output."})
+	timeRange := parser.String("r", "range", &argparse.Options{Required: false, Help: "Time range to search backwards from the current moment. Units are s, m, h, d, w, mo and y, e.g., 30m, 2h, 3d12h, 1w, or an ISO-8601 duration such as PT30M. Default: " + DefaultRange})
+	start := parser.String("", "start", &argparse.Options{Required: false, Help: "Starting time to search from. Allows variable formats, including '1:32pm', '1/4/2019 12:30:00', 'yesterday 14:00', 'monday', 'today' or 'now-15m'."})
+	end := parser.String("", "end", &argparse.Options{Required: false, Help: "Ending time to search from. Allows the same formats as --start. Defaults to now if --start is provided but no --end."})
+	around := parser.String("", "around", &argparse.Options{Required: false, Help: "Search a window centered on a time, e.g., --around '2019-01-04 12:30' or --around 'yesterday 9am'. Allows the same formats as --start. Can't be combined with --start or --end."})
+	window := parser.String("", "window", &argparse.Options{Required: false, Help: "How far before and after the --around time to search, e.g., 30s or 10m. Default: " + DefaultWindow + ", or " + DefaultContextWindow + " for the context command."})
+	before := parser.Int("", "before", &argparse.Options{Required: false, Help: "The number of messages shown before the message of the context command.", Default: 20})
+	after := parser.Int("", "after", &argparse.Options{Required: false, Help: "The number of messages shown after the message of the context command.", Default: 20})
+	same := parser.Selector("", "same", []string{sameSource, sameApplication, sameStream}, &argparse.Options{Required: false, Help: "Which messages the context command shows: the ones from the same source, application or streams as the message.", Default: sameSource})
+	json := parser.Flag("j", "json", &argparse.Options{Required: false, Help: "Output messages in json format. Shows the modified log message, not the untouched message from Graylog. Useful in understanding the fields available when creating Format templates or for further processing. With the show command, prints the untouched message."})
+	noColor := parser.Flag("", "no-colors", &argparse.Options{Required: false, Help: "Don't use colors in output. Same as --color=never."})
+	noPager := parser.Flag("", "no-pager", &argparse.Options{Required: false, Help: "Don't send search results through $PAGER (or 'less -R') when they don't fit on the screen."})
+	tz := parser.String("", "tz", &argparse.Options{Required: false, Help: "Timezone used to display timestamps and to interpret --start and --end: UTC, Local or an IANA name such as 'America/New_York'. Default: the 'timezone' config setting, otherwise Local."})
+	timeFormat := parser.String("", "time-format", &argparse.Options{Required: false, Help: "Layout of the _long_time_timestamp field, in Go time format. Default: the 'timeFormat' config setting, otherwise '" + config.DefaultTimeFormat + "'."})
+	fullTraces := parser.Flag("", "full-traces", &argparse.Options{Required: false, Help: "Show complete stacktraces. By default, stacktraces are folded to the exception lines and the first application frames."})
+	archivePath := parser.String("", "from-file", &argparse.Options{Required: false, Help: "Search the messages in an archive file created by the save command instead of Graylog. Only the --start/--end window, --stream and --limit are applied."})
+	where := parser.StringList("", "where", &argparse.Options{Required: false, Help: "Only show (or export) the messages matching an expression over their fields, evaluated after the formats' helper fields are added, e.g., 'loglevel == \"ERROR\" and _message_text =~ \"timeout\"'. Supports ==, !=, =~, !~, <, <=, >, >=, and, or, not, exists and parentheses. Can be repeated; every expression must match."})
+	format := parser.String("", "format", &argparse.Options{Required: false, Help: "Display messages with a single format: the name of a [formats] entry, or a template, e.g., '{{.source}}: {{._message_text}}'. Default: the first [formats] entry that applies."})
+	columns := parser.String("", "columns", &argparse.Options{Required: false, Help: "Display the fields, separated by tabs, instead of a format. Format is 'field1,field2,field3...'."})
+	colorMode := parser.Selector("", "color", []string{colorAuto, colorAlways, colorNever}, &argparse.Options{Required: false, Help: "When to use colors in output: auto (only when writing to a terminal), always or never.", Default: colorAuto})
 
-	if err := parser.Parse(os.Args); err != nil {
+	command, commandArgs, args := splitCommand(os.Args)
+	if err := parser.Parse(args); err != nil {
+		invalidArgs(parser, err, "")
+	}
+	if required := requiredArguments(command); len(commandArgs) < len(required) {
+		invalidArgs(parser, nil, fmt.Sprintf("The %s command requires <%s>", command,
+			strings.Join(required, "> <")))
+	}
+	// Read the configuration file
+	cfg, err := config.New(*configPath)
+	if err != nil {
 		invalidArgs(parser, err, "")
 	}
-	if len(*fields) > 0 && len(*start) == 0 {
-		invalidArgs(parser, nil, "The --export option requires the --start option")
+
+	// The flags of a saved search, before any defaults are applied
+	search := config.SavedSearch{Query: *query, Streams: *streamNames, Range: *timeRange, Format: *format, Columns: *columns}
+
+	// Settings of a saved search apply unless they're overridden by flags. Searches in the config file win over the
+	// ones saved in Graylog.
+	var serverStreamIds []string
+	if command == runCommand {
+		saved, ok := cfg.Search(commandArgs[0])
+		if !ok {
+			saved, serverStreamIds = runServerSearch(parser, cfg, commandArgs, start, end)
+		}
+		saved, err = expandSearch(saved, commandArgs[1:])
+		if err != nil {
+			invalidArgs(parser, err, "Can't run the saved search")
+		}
+		for _, s := range []struct {
+			flag  *string
+			saved string
+		}{
+			{query, saved.Query},
+			{streamNames, saved.Streams},
+			{timeRange, saved.Range},
+			{format, saved.Format},
+			{columns, saved.Columns},
+		} {
+			if len(*s.flag) == 0 {
+				*s.flag = s.saved
+			}
+		}
+	}
+	if len(*timeRange) == 0 {
+		*timeRange = DefaultRange
+	}
+
+	if len(*tz) == 0 {
+		*tz = cfg.Timezone()
+	}
+	location, err := time.LoadLocation(*tz)
+	if err != nil {
+		invalidArgs(parser, err, "Unknown timezone")
+	}
+	if len(*timeFormat) == 0 {
+		*timeFormat = cfg.TimeFormat()
 	}
 
-	startDate := strToDate(parser, *start, "The --start date can't be parsed", false)
-	endDate := strToDate(parser, *end, "The --end date can't be parsed", true)
+	startDate := strToDate(parser, *start, "The --start date can't be parsed", false, location)
+	endDate := strToDate(parser, *end, "The --end date can't be parsed", true, location)
+
+	if len(*around) > 0 {
+		if len(*start) > 0 || len(*end) > 0 {
+			invalidArgs(parser, nil, "--around can't be combined with --start or --end")
+		}
+		if len(*window) == 0 {
+			*window = DefaultWindow
+		}
+		center := strToDate(parser, *around, "The --around time can't be parsed", false, location)
+		half := time.Duration(timeRangeToSeconds(parser, *window)) * time.Second
+		from, to := center.Add(-half), center.Add(half)
+		startDate, endDate = &from, &to
+	} else if len(*window) > 0 && command != contextCommand {
+		invalidArgs(parser, nil, "--window requires --around")
+	}
+	if startDate != nil && !startDate.Before(*endDate) {
+		invalidArgs(parser, nil, "The --start date must be before the --end date")
+	}
 
 	if *limit <= 0 {
 		var newLimit = DefaultLimit
 		limit = &newLimit
 	}
 
+	multiTail := len(*tailQueries) > 0 || len(*tailSearches) > 0
+	if multiTail {
+		if startDate != nil || len(*fields) > 0 || len(*archivePath) > 0 || *stats || *statsOnly {
+			invalidArgs(parser, nil, "--tail-query and --tail-search need a relative search, and can't be combined with --export, --from-file or --stats")
+		}
+		*tail = true
+	}
+
 	if startDate != nil {
 		var newTail = false
 		tail = &newTail
 	}
 
-	var newQuery string
-	if len(*application) > 0 {
-		newQuery = "application:" + *application
-		if len(*query) > 0 {
-			newQuery += " AND " + *query
+	// Exports of a relative range use the absolute window as of now, except when recording a tail
+	if len(*fields) > 0 && startDate == nil && !*tail {
+		now := time.Now().In(location)
+		from := now.Add(-time.Duration(timeRangeToSeconds(parser, *timeRange)) * time.Second)
+		startDate = &from
+		endDate = &now
+	}
+
+	if len(*application) > 0 || len(*fieldTerms) > 0 || len(*notTerms) > 0 || len(*level) > 0 ||
+		len(*sources) > 0 || len(*exists) > 0 {
+		newQuery, err := buildQuery(queryTerms{
+			query:       *query,
+			application: *application,
+			fields:      *fieldTerms,
+			not:         *notTerms,
+			level:       *level,
+			sources:     *sources,
+			exists:      *exists,
+		})
+		if err != nil {
+			invalidArgs(parser, err, "Invalid query")
 		}
 		query = &newQuery
 	}
 
+	if len(*archivePath) > 0 {
+		var newTail = false
+		tail = &newTail
+		if len(*query) > 0 {
+			fmt.Fprintln(os.Stderr, "Queries can't be applied to --from-file archives, ignoring the query")
+		}
+	}
+
+	if (*stats || *statsOnly) && (!*tail || len(*fields) > 0) {
+		invalidArgs(parser, nil, "--stats requires --tail and a relative search, and can't be combined with --export")
+	}
+
 	opts := options{
-		listStreams: *listStreams,
-		application: *application,
-		query:       *query,
-		fields:      *fields,
-		limit:       *limit,
-		tail:        *tail,
-		configPath:  *configPath,
-		timeRange:   timeRangeToSeconds(parser, *timeRange),
-		startDate:   startDate,
-		endDate:     endDate,
-		json:        *json,
-		noColor:     *noColor || isTty(),
+		command:      command,
+		commandArgs:  commandArgs,
+		archivePath:  *archivePath,
+		listStreams:  *listStreams,
+		application:  *application,
+		query:        *query,
+		fields:       *fields,
+		limit:        *limit,
+		tail:         *tail,
+		stats:        *stats || *statsOnly,
+		statsOnly:    *statsOnly,
+		configPath:   *configPath,
+		timeRange:    timeRangeToSeconds(parser, *timeRange),
+		startDate:    startDate,
+		endDate:      endDate,
+		json:         *json,
+		spinner:      isTerminal(os.Stderr) && !*stats && !*statsOnly,
+		pager:        !*noPager && !*tail && isTerminal(os.Stdout),
+		location:     location,
+		timeFormat:   *timeFormat,
+		exportPath:   *exportPath,
+		exportFormat: *exportFormat,
+		parallel:     *parallel,
+		resume:       *resume,
+		record:       *tail && len(*fields) > 0,
+		force:        *force,
 	}
 
-	// Read the configuration file
-	cfg, err := config.New(opts.configPath)
+	if command == contextCommand {
+		opts.contextBefore = *before
+		opts.contextAfter = *after
+		opts.contextSame = *same
+		if len(*window) == 0 {
+			*window = DefaultContextWindow
+		}
+		opts.contextWindow = time.Duration(timeRangeToSeconds(parser, *window)) * time.Second
+	}
+
+	opts.rotateSize, err = parseSize(*rotateSize)
 	if err != nil {
-		invalidArgs(parser, err, "")
+		invalidArgs(parser, err, "The --rotate-size can't be parsed")
+	}
+
+	if len(*chunk) > 0 {
+		opts.exportChunk = timeRangeToSeconds(parser, *chunk)
+		if opts.exportChunk <= 0 {
+			invalidArgs(parser, nil, "The --chunk size must be greater than 0")
+		}
+	}
+
+	if len(opts.exportFormat) == 0 {
+		opts.exportFormat = exportFormatFromPath(opts.exportPath)
+	}
+	if err := checkExportCompression(opts.exportFormat, opts.exportPath); err != nil {
+		invalidArgs(parser, err, "Invalid --out")
 	}
 
+	if *noColor {
+		*colorMode = colorNever
+	}
+	opts.useColors = decideColors(*colorMode, os.Getenv, isTerminal(os.Stdout))
+
 	opts.serverConfig = cfg
 
+	opts.colors, err = newColorScheme(cfg.Colors())
+	if err != nil {
+		invalidArgs(parser, err, "Invalid [colors] configuration")
+	}
+
+	opts.formats, err = selectFormats(cfg, *format)
+	if err != nil {
+		invalidArgs(parser, err, "Invalid --format")
+	}
+	opts.columns = exportFields(*columns)
+
+	opts.where, err = parseWhereList(*where)
+	if err != nil {
+		invalidArgs(parser, err, "Invalid --where expression")
+	}
+
+	opts.extractions, err = newExtractionRules(cfg.Extractions())
+	if err != nil {
+		invalidArgs(parser, err, "Invalid [extract] configuration")
+	}
+
+	opts.extractors, err = newFieldExtractors(cfg.Extractors())
+	if err != nil {
+		invalidArgs(parser, err, "Invalid [extractors] configuration")
+	}
+
+	if !*fullTraces {
+		opts.traces = &traceFolding{frames: cfg.TraceFrames(), packages: cfg.TracePackages()}
+	}
+
+	if command == searchesSaveCommand {
+		search.Query = opts.query
+		opts.search = search
+		return &opts
+	}
+	if command == searchesListCommand {
+		return &opts
+	}
+
 	// Convert the stream names into Graylog stream ids
 	if len(*streamNames) > 0 {
 		opts.streamIds = findStreamIds(&opts, *streamNames)
@@ -123,69 +420,150 @@ func parseArgs() *options {
 		}
 	}
 
+	if len(opts.streamIds) == 0 && len(serverStreamIds) > 0 {
+		opts.streamIds = serverStreamIds
+	}
+
+	if len(*onMatch) > 0 {
+		if !opts.tail || opts.record {
+			invalidArgs(parser, nil, "--on-match requires --tail and can't be combined with --export")
+		}
+		if len(*cooldown) == 0 {
+			*cooldown = DefaultCooldown
+		}
+		wait, err := parseDuration(*cooldown)
+		if err != nil {
+			invalidArgs(parser, err, "The --cooldown can't be parsed")
+		}
+		if *maxActions <= 0 {
+			invalidArgs(parser, nil, "The --max-actions must be greater than 0")
+		}
+		opts.actions, err = newMatchActions(*onMatch, wait, *maxActions)
+		if err != nil {
+			invalidArgs(parser, err, "Invalid --on-match rule")
+		}
+	} else if len(*cooldown) > 0 {
+		invalidArgs(parser, nil, "--cooldown requires --on-match")
+	}
+
+	if multiTail {
+		opts.tailQueries, err = newTailQueries(&opts, *tailQueries, *tailSearches)
+		if err != nil {
+			invalidArgs(parser, err, "Invalid --tail-query or --tail-search")
+		}
+	}
+
+	if *showQuery {
+		if len(opts.tailQueries) == 0 {
+			printQuery(&opts)
+		}
+		for _, q := range opts.tailQueries {
+			fmt.Fprintf(os.Stderr, "[%s]\n", q.name)
+			printQuery(q.opts)
+		}
+	}
+
 	return &opts
 }
 
-// Convert a variable human-friendly date into a time.Time.
-func strToDate(parser *argparse.Parser, dateStr string, errorStr string, defaultToNow bool) *time.Time {
-	var dateTime time.Time
-	var err error
+// Look up a search saved in Graylog for the run command, translated into the settings of a config file search. Absolute
+// time ranges are copied into the --start and --end flags, unless they're given.
+// returns: the search settings and the ids of the streams it searches.
+func runServerSearch(parser *argparse.Parser, cfg *config.IniFile, commandArgs []string, start *string,
+	end *string) (config.SavedSearch, []string) {
+	name := commandArgs[0]
+	searches, err := fetchServerSearches(&options{serverConfig: cfg})
+	if err != nil {
+		invalidArgs(parser, err, fmt.Sprintf("No saved search named '%s' in the config file, and Graylog's saved searches can't be fetched", name))
+	}
+	search, ok := findServerSearch(searches, name)
+	if !ok {
+		invalidArgs(parser, nil, fmt.Sprintf("No saved search named '%s', see 'graylog searches list'", name))
+	}
+	if len(commandArgs) > 1 {
+		invalidArgs(parser, nil, "Searches saved in Graylog don't take arguments")
+	}
+	if len(search.keyword) > 0 {
+		fmt.Fprintf(os.Stderr, "The '%s' time range of the saved search isn't supported, using --range\n", search.keyword)
+	}
+	if len(search.from) > 0 && len(*start) == 0 {
+		*start = search.from
+		if len(*end) == 0 {
+			*end = search.to
+		}
+	}
+	return config.SavedSearch{
+		Name:    search.title,
+		Query:   search.query,
+		Range:   search.rangeText,
+		Columns: search.fields,
+	}, search.streamIds
+}
+
+// The positional arguments a command can't do without.
+func requiredArguments(command string) []string {
+	positional := commandArguments[command]
+	if len(positional) > 0 && strings.HasSuffix(positional[len(positional)-1], "...") {
+		return positional[:len(positional)-1]
+	}
+	return positional
+}
 
-	if len(dateStr) > 0 {
-		// Check to see if the date is a time only
-		matched, _ := regexp.MatchString("^[0-9]{1,2}:[0-9]{2}(:[0-9]{2})?([ ]*(am|pm|AM|PM)?)?$", dateStr)
-		if matched {
-			dateStr = time.Now().Format("2006-01-02") + " " + dateStr
+// Split a command, and its positional arguments, off the command-line arguments.
+// returns: the command (empty if none), its arguments and the remaining arguments for the parser.
+func splitCommand(args []string) (command string, commandArgs []string, remaining []string) {
+	remaining = args
+	if len(args) < 2 {
+		return "", nil, remaining
+	}
+	command = args[1]
+	rest := args[2:]
+	if len(args) > 2 {
+		if _, ok := commandArguments[args[1]+" "+args[2]]; ok {
+			command = args[1] + " " + args[2]
+			rest = args[3:]
 		}
-		dateTime, err = dateparse.ParseLocal(dateStr)
+	}
+	positional, ok := commandArguments[command]
+	if !ok {
+		return "", nil, remaining
+	}
+
+	variadic := len(positional) > 0 && strings.HasSuffix(positional[len(positional)-1], "...")
+	for (len(commandArgs) < len(positional) || variadic) && len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		commandArgs = append(commandArgs, rest[0])
+		rest = rest[1:]
+	}
+	remaining = append([]string{args[0]}, rest...)
+	return command, commandArgs, remaining
+}
+
+// Convert a date or time expression into a time.Time, see parseTimeExpression. Dates without a timezone are taken to
+// be in the given location.
+func strToDate(parser *argparse.Parser, dateStr string, errorStr string, defaultToNow bool, loc *time.Location) *time.Time {
+	if len(dateStr) > 0 {
+		dateTime, err := parseTimeExpression(dateStr, time.Now(), loc)
 		if err != nil {
 			invalidArgs(parser, err, errorStr)
-		} else {
-			if dateTime.Year() == 0 {
-				dateTime = dateTime.AddDate(time.Now().Year(), 0, 0)
-			}
-		}
-		if err != nil {
 			return nil
 		}
 		return &dateTime
 	}
 	if defaultToNow {
-		dateTime = time.Now()
+		dateTime := time.Now()
 		return &dateTime
 	}
 	return nil
 }
 
-// Converts a simple human-friendly time range into seconds, e.g., 2h for 2 hours, 3d2h30m for 3 days, 2 hours and
-// 30 minutes.
+// Converts a human-friendly time range into seconds, e.g., 2h for 2 hours, 3d2h30m for 3 days, 2 hours and 30 minutes,
+// 1w, 6mo or PT30M. See parseDuration.
 func timeRangeToSeconds(parser *argparse.Parser, timeRange string) int {
-	re := regexp.MustCompile("([0-9]*)([a-zA-Z]*)")
-	parts := re.FindAllString(timeRange, -1)
-	var accumulator int
-	for _, part := range parts {
-		if len(part) > 1 {
-			unit := part[len(part)-1:]
-			numberStr := part[:len(part)-1]
-			num, err := strconv.Atoi(numberStr)
-			if err != nil {
-				invalidArgs(parser, err, "Time range can't be parsed")
-			}
-			switch strings.ToLower(unit) {
-			case "s":
-				accumulator += num
-			case "m":
-				accumulator += num * 60
-			case "h":
-				accumulator += num * 3600
-			case "d":
-				accumulator += num * 86400
-			default:
-				invalidArgs(parser, err, "Time range can't be parsed")
-			}
-		}
+	d, err := parseDuration(timeRange)
+	if err != nil {
+		invalidArgs(parser, err, "Time range can't be parsed")
 	}
-	return accumulator
+	return int(d / time.Second)
 }
 
 // Display the help message when a command-line argument is invalid.
@@ -217,8 +595,8 @@ func expandPath(configPath string) string {
 	return path
 }
 
-// Check to see whether we're outputting to a terminal or if we've been redirected to a file
-func isTty() bool {
-	_, err := unix.IoctlGetTermios(int(os.Stdout.Fd()), unix.TIOCGETA)
+// Check to see whether a file is a terminal or if it's been redirected to a file or pipe.
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), ioctlReadTermios)
 	return err == nil
 }
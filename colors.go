@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const debugEsc = "\033[94m"
+const errorEsc = "\033[91m"
+const infoEsc = "\033[92m"
+const resetEsc = "\033[0;0m"
+const warnEsc = "\033[93m"
+
+const boldEsc = "\033[1m"
+
+// Prefix of the helper fields holding the color escape for a configured field, e.g., _color_source.
+const colorFieldPrefix = "_color_"
+
+// Special color value that picks a stable color for each distinct field value.
+const hashColor = "hash"
+
+// Standard ANSI foreground colors and text attributes, by name.
+var ansiCodes = map[string]string{
+	"black":          "30",
+	"red":            "31",
+	"green":          "32",
+	"yellow":         "33",
+	"blue":           "34",
+	"magenta":        "35",
+	"cyan":           "36",
+	"white":          "37",
+	"gray":           "90",
+	"grey":           "90",
+	"bright-red":     "91",
+	"bright-green":   "92",
+	"bright-yellow":  "93",
+	"bright-blue":    "94",
+	"bright-magenta": "95",
+	"bright-cyan":    "96",
+	"bright-white":   "97",
+	"bold":           "1",
+	"dim":            "2",
+	"italic":         "3",
+	"underline":      "4",
+}
+
+// Colors used for the log levels when the configuration doesn't override them.
+var defaultLevelColors = map[string]string{
+	traceLevel:     debugEsc,
+	debugLevel:     debugEsc,
+	infoLevel:      infoEsc,
+	noticeLevel:    infoEsc,
+	warnLevel:      warnEsc,
+	errorLevel:     errorEsc,
+	criticalLevel:  errorEsc,
+	alertLevel:     errorEsc,
+	emergencyLevel: errorEsc,
+	fatalLevel:     errorEsc,
+}
+
+// Numeric syslog severities (as sent in GELF messages) and the level names they correspond to.
+var syslogLevels = map[string]string{
+	"0": emergencyLevel,
+	"1": alertLevel,
+	"2": criticalLevel,
+	"3": errorLevel,
+	"4": warnLevel,
+	"5": noticeLevel,
+	"6": infoLevel,
+	"7": debugLevel,
+}
+
+// 256-color palette used for hash-based coloring. Picked to be readable on both dark and light backgrounds.
+var hashPalette = []int{
+	27, 33, 39, 41, 45, 69, 75, 77, 81, 99, 105, 111, 113, 119, 141, 147,
+	149, 161, 166, 167, 172, 178, 184, 197, 202, 203, 208, 214, 220, 35, 71, 133,
+}
+
+// A single value=color rule for a field.
+type valueColor struct {
+	pattern string
+	esc     string
+}
+
+// The color settings for a single message field.
+type fieldColor struct {
+	name   string
+	esc    string
+	hash   bool
+	values []valueColor
+}
+
+// colorScheme holds the level and field colors used when formatting messages.
+type colorScheme struct {
+	levels map[string]string
+	fields []fieldColor
+}
+
+// Build the color scheme from the [colors] section of the configuration. Level colors are declared as
+// 'level.<LEVEL>: <color>' and field colors as 'field.<name>: <color>|hash|<value>=<color>,...'.
+func newColorScheme(settings map[string]string) (*colorScheme, error) {
+	scheme := colorScheme{levels: make(map[string]string)}
+	for level, esc := range defaultLevelColors {
+		scheme.levels[level] = esc
+	}
+
+	var keys []string
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := strings.TrimSpace(settings[key])
+		switch {
+		case strings.HasPrefix(key, "level."):
+			esc, err := parseColor(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err.Error())
+			}
+			scheme.levels[strings.ToUpper(strings.TrimPrefix(key, "level."))] = esc
+		case strings.HasPrefix(key, "field."):
+			field, err := parseFieldColor(strings.TrimPrefix(key, "field."), value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err.Error())
+			}
+			scheme.fields = append(scheme.fields, field)
+		default:
+			return nil, fmt.Errorf("%s: color keys must start with 'level.' or 'field.'", key)
+		}
+	}
+
+	return &scheme, nil
+}
+
+// Parse the color settings for a single field.
+func parseFieldColor(name string, spec string) (field fieldColor, err error) {
+	field.name = name
+	if strings.ToLower(spec) == hashColor {
+		field.hash = true
+		return field, nil
+	}
+	if !strings.Contains(spec, "=") {
+		field.esc, err = parseColor(spec)
+		return field, err
+	}
+	for _, rule := range strings.Split(spec, ",") {
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 {
+			return field, fmt.Errorf("expected <value>=<color>, found '%s'", strings.TrimSpace(rule))
+		}
+		esc, err := parseColor(parts[1])
+		if err != nil {
+			return field, err
+		}
+		field.values = append(field.values, valueColor{pattern: strings.TrimSpace(parts[0]), esc: esc})
+	}
+	return field, nil
+}
+
+// Convert a color description into an ANSI escape sequence. A description is a space separated list of color names
+// and attributes (e.g., 'bold red'), 256-color numbers (0-255) or truecolor values (#rrggbb).
+func parseColor(spec string) (string, error) {
+	var codes []string
+	for _, part := range strings.Fields(strings.ToLower(spec)) {
+		if code, ok := ansiCodes[part]; ok {
+			codes = append(codes, code)
+		} else if strings.HasPrefix(part, "#") && len(part) == 7 {
+			rgb, err := strconv.ParseUint(part[1:], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid truecolor value '%s'", part)
+			}
+			codes = append(codes, fmt.Sprintf("38;2;%d;%d;%d", rgb>>16, (rgb>>8)&0xff, rgb&0xff))
+		} else if n, err := strconv.Atoi(part); err == nil && n >= 0 && n <= 255 {
+			codes = append(codes, "38;5;"+part)
+		} else {
+			return "", fmt.Errorf("unknown color '%s'", part)
+		}
+	}
+	if len(codes) == 0 {
+		return "", fmt.Errorf("no color given")
+	}
+	return "\033[" + strings.Join(codes, ";") + "m", nil
+}
+
+// Find the color for a log level. Numeric syslog levels use the color of the matching level name unless they have
+// a color of their own.
+func (s *colorScheme) levelColor(level string) string {
+	if esc, ok := s.levels[level]; ok {
+		return esc
+	}
+	if name, ok := syslogLevels[level]; ok {
+		return s.levels[name]
+	}
+	return ""
+}
+
+// Find the color for a field value.
+func (f *fieldColor) color(value string) string {
+	if f.hash {
+		return hashedColor(value)
+	}
+	for _, v := range f.values {
+		if matchesColorPattern(v.pattern, value) {
+			return v.esc
+		}
+	}
+	return f.esc
+}
+
+// Check whether a value matches a color rule pattern. Patterns are either exact (case-insensitive) values, digit
+// masks such as '5xx', or inclusive numeric ranges such as '400-499'.
+func matchesColorPattern(pattern string, value string) bool {
+	if strings.EqualFold(pattern, value) {
+		return true
+	}
+	if strings.ContainsAny(pattern, "xX") && len(pattern) == len(value) {
+		for i := range pattern {
+			if pattern[i] == 'x' || pattern[i] == 'X' {
+				if value[i] < '0' || value[i] > '9' {
+					return false
+				}
+			} else if pattern[i] != value[i] {
+				return false
+			}
+		}
+		return true
+	}
+	if bounds := strings.SplitN(pattern, "-", 2); len(bounds) == 2 {
+		lo, err1 := strconv.ParseFloat(bounds[0], 64)
+		hi, err2 := strconv.ParseFloat(bounds[1], 64)
+		n, err3 := strconv.ParseFloat(value, 64)
+		if err1 == nil && err2 == nil && err3 == nil {
+			return n >= lo && n <= hi
+		}
+	}
+	return false
+}
+
+// Pick a color for a value from the hash palette, so the same value (e.g., a source host) always gets the same color.
+func hashedColor(value string) string {
+	if len(value) == 0 {
+		return ""
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(value))
+	return fmt.Sprintf("\033[38;5;%dm", hashPalette[h.Sum32()%uint32(len(hashPalette))])
+}
+
+// Decide whether colors are used. The 'auto' mode honors the NO_COLOR (https://no-color.org) and CLICOLOR_FORCE
+// conventions before falling back to whether the output is a terminal.
+func decideColors(mode string, getenv func(string) string, terminal bool) bool {
+	switch mode {
+	case colorAlways:
+		return true
+	case colorNever:
+		return false
+	}
+	if len(getenv("NO_COLOR")) > 0 {
+		return false
+	}
+	if force := getenv("CLICOLOR_FORCE"); len(force) > 0 && force != "0" {
+		return true
+	}
+	return terminal
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"fmt"
+	"io"
+	"os"
 	"sort"
 	"strings"
 )
@@ -59,8 +62,19 @@ func commandListMessages(opts *options) ([]logMessage, map[string]map[string]str
 	return messages, streams
 }
 
-func printMessages(messages []logMessage, opts *options, streams map[string]map[string]string) {
+// Save the log messages that match the search criteria into an archive file.
+func commandSave(opts *options) {
+	path := opts.commandArgs[0]
+	messages, streams := commandListMessages(opts)
+	if err := saveArchive(path, messages, streams); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to save to '%s': %s\n", path, err.Error())
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Saved %d messages to %s\n", len(messages), path)
+}
+
+func printMessages(w io.Writer, messages []logMessage, opts *options, streams map[string]map[string]string) {
 	for _, msg := range messages {
-		printMessage(opts, streams, msg)
+		printMessage(w, opts, streams, msg)
 	}
 }
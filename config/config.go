@@ -9,12 +9,22 @@ import (
 	"path/filepath"
 )
 
+const colorsSection string = "colors"
+const displaySection string = "display"
+const extractSection string = "extract"
+const extractorsSection string = "extractors"
 const formatsSection string = "formats"
+const searchesSection string = "searches"
 const serverSection string = "server"
+const tracesSection string = "traces"
+
+// DefaultTimeFormat is the timestamp layout used when none is configured. Renders 'Z' for UTC, otherwise the offset.
+const DefaultTimeFormat = "2006-01-02T15:04:05.000Z07:00"
 
 // IniFile is a wrapper around the INI file reader
 type IniFile struct {
-	ini *ini.File
+	ini  *ini.File
+	path string
 }
 
 // FormatDefinition stores a single format line.
@@ -23,11 +33,34 @@ type FormatDefinition struct {
 	Format string
 }
 
+// ExtractionDefinition stores a single rule for extracting embedded JSON or key=value payloads.
+type ExtractionDefinition struct {
+	Name string
+	Rule string
+}
+
+// ExtractorDefinition stores a single derived field: a regex over another field, or a template.
+type ExtractorDefinition struct {
+	Field string
+	Rule  string
+}
+
+// SavedSearch stores a named search from a [searches.<name>] section. Values may contain {{.arg1}}, {{.arg2}}, ...
+// placeholders for the arguments given when running it.
+type SavedSearch struct {
+	Name    string
+	Query   string
+	Streams string
+	Range   string
+	Format  string
+	Columns string
+}
+
 // New creates a new INI file reader and wraps it.
 func New(configPath string) (*IniFile, error) {
 	f, err := readConfig(configPath)
 	if err == nil {
-		return &IniFile{ini: f}, nil
+		return &IniFile{ini: f, path: configPath}, nil
 	} else {
 		return nil, err
 	}
@@ -57,6 +90,31 @@ func (c *IniFile) IgnoreCert() bool {
 	return server.Key("ignoreCert").MustBool(false)
 }
 
+// Timezone gets the timezone used to display timestamps from the config file. Defaults to "Local".
+func (c *IniFile) Timezone() string {
+	display := c.ini.Section(displaySection)
+	return display.Key("timezone").MustString("Local")
+}
+
+// TimeFormat gets the layout used to display timestamps from the config file. Defaults to DefaultTimeFormat.
+func (c *IniFile) TimeFormat() string {
+	display := c.ini.Section(displaySection)
+	return display.Key("timeFormat").MustString(DefaultTimeFormat)
+}
+
+// TraceFrames gets the number of application frames kept when folding stacktraces. Defaults to 3.
+func (c *IniFile) TraceFrames() int {
+	traces := c.ini.Section(tracesSection)
+	return traces.Key("frames").MustInt(3)
+}
+
+// TracePackages gets the package (or path) prefixes that identify application frames in stacktraces. Defaults to
+// none, meaning every frame is an application frame.
+func (c *IniFile) TracePackages() []string {
+	traces := c.ini.Section(tracesSection)
+	return traces.Key("packages").Strings(",")
+}
+
 // Formats gets the log messages formats from the config file. Adds a final default format case so the user knows that
 // no formats were applied successfully.
 func (c *IniFile) Formats() (formats []FormatDefinition) {
@@ -68,6 +126,89 @@ func (c *IniFile) Formats() (formats []FormatDefinition) {
 	return formats
 }
 
+// Extractions gets the rules for extracting embedded JSON or key=value payloads from the config file.
+func (c *IniFile) Extractions() (extractions []ExtractionDefinition) {
+	for _, k := range c.ini.Section(extractSection).Keys() {
+		extractions = append(extractions, ExtractionDefinition{Name: k.Name(), Rule: k.Value()})
+	}
+	return extractions
+}
+
+// Extractors gets the derived field definitions from the config file, in the order they're defined.
+func (c *IniFile) Extractors() (extractors []ExtractorDefinition) {
+	for _, k := range c.ini.Section(extractorsSection).Keys() {
+		extractors = append(extractors, ExtractorDefinition{Field: k.Name(), Rule: k.Value()})
+	}
+	return extractors
+}
+
+// Searches gets the saved searches from the config file, in the order they're defined.
+func (c *IniFile) Searches() (searches []SavedSearch) {
+	for _, s := range c.ini.Section(searchesSection).ChildSections() {
+		searches = append(searches, SavedSearch{
+			Name:    s.Name()[len(searchesSection)+1:],
+			Query:   s.Key("query").String(),
+			Streams: s.Key("streams").String(),
+			Range:   s.Key("range").String(),
+			Format:  s.Key("format").String(),
+			Columns: s.Key("columns").String(),
+		})
+	}
+	return searches
+}
+
+// Search gets a saved search by name.
+func (c *IniFile) Search(name string) (SavedSearch, bool) {
+	for _, s := range c.Searches() {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return SavedSearch{}, false
+}
+
+// SaveSearch adds a saved search to the config file, replacing any search with the same name. The file is read again
+// before it's written, so only the saved search changes.
+func (c *IniFile) SaveSearch(search SavedSearch) error {
+	f, err := readConfig(c.path)
+	if err != nil {
+		return err
+	}
+	name := searchesSection + "." + search.Name
+	f.DeleteSection(name)
+	section, err := f.NewSection(name)
+	if err != nil {
+		return err
+	}
+	settings := []struct{ key, value string }{
+		{"query", search.Query},
+		{"streams", search.Streams},
+		{"range", search.Range},
+		{"format", search.Format},
+		{"columns", search.Columns},
+	}
+	for _, s := range settings {
+		if len(s.value) > 0 {
+			if _, err := section.NewKey(s.key, s.value); err != nil {
+				return err
+			}
+		}
+	}
+	if err := f.SaveTo(c.path); err != nil {
+		return fmt.Errorf("configuration file cannot be written at %s: %w", c.path, err)
+	}
+	return nil
+}
+
+// Colors gets the level and field color settings from the config file, keyed by setting name.
+func (c *IniFile) Colors() map[string]string {
+	colors := make(map[string]string)
+	for _, k := range c.ini.Section(colorsSection).Keys() {
+		colors[k.Name()] = k.Value()
+	}
+	return colors
+}
+
 // Reads the configuration file. The configuration is stored in a INI style file.
 func readConfig(configPath string) (cfg *ini.File, err error) {
 	configPath, err = filepath.Abs(configPath)
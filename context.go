@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+)
+
+const messageInfo = "messages/%s/%s"
+
+// Relative range of zero searches all messages.
+const allTimeSearch = "search/universal/relative?range=0&limit=1&query=%s"
+
+// DefaultContextWindow is how far before and after the message the context command searches when no --window is
+// provided by the user.
+const DefaultContextWindow = "1d"
+
+// Values accepted by the --same option.
+const sameSource = "source"
+const sameApplication = "application"
+const sameStream = "stream"
+
+// Print the messages logged just before and after a message, by the same source, application or stream.
+func commandContext(opts *options) {
+	anchor := fetchMessage(opts, opts.commandArgs[0])
+	streams := fetchStreams(opts)
+
+	contextOpts := *opts
+	contextOpts.fields = ""
+	switch opts.contextSame {
+	case sameStream:
+		if len(opts.streamIds) == 0 {
+			contextOpts.streamIds = anchor.streams
+		}
+	default:
+		value, ok := anchor.fields[opts.contextSame]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "The message has no %s field, showing the messages of every %s\n",
+				opts.contextSame, opts.contextSame)
+			break
+		}
+		term := fieldTerm(opts.contextSame, value)
+		if len(opts.query) > 0 {
+			term = "(" + opts.query + ") AND " + term
+		}
+		contextOpts.query = term
+	}
+
+	before := searchAround(&contextOpts, anchor.timestamp.Add(-opts.contextWindow), anchor.timestamp, opts.contextBefore, "desc")
+	after := searchAround(&contextOpts, anchor.timestamp, anchor.timestamp.Add(opts.contextWindow), opts.contextAfter, "asc")
+
+	// Messages logged in the same millisecond as the anchor can be found by both searches
+	seen := map[string]bool{anchor.id: true}
+	messages := []logMessage{anchor}
+	for _, msg := range append(before, after...) {
+		if !seen[msg.id] {
+			seen[msg.id] = true
+			messages = append(messages, msg)
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].timestamp.Before(messages[j].timestamp)
+	})
+
+	var output bytes.Buffer
+	for _, msg := range messages {
+		printContextMessage(&output, opts, streams, msg, msg.id == anchor.id)
+	}
+	writePaged(&output, opts.pager)
+}
+
+// Fetch a single message by its id, or by '<index>/<id>'.
+func fetchMessage(opts *options, id string) logMessage {
+	index, body := fetchRawMessage(opts, id)
+	messages := parseMessages([]byte(`{"messages":[` + string(body) + `]}`))
+	if len(messages) == 0 {
+		fmt.Fprintf(os.Stderr, "Unable to read the message '%s' of the index '%s'\n", id, index)
+		os.Exit(1)
+	}
+	return messages[0]
+}
+
+// Fetch the untouched Graylog response for a message, and the index it's stored in. Without an index, the message is
+// first searched for to find its index.
+func fetchRawMessage(opts *options, id string) (index string, body []byte) {
+	if i := strings.Index(id, "/"); i >= 0 {
+		index, id = id[:i], id[i+1:]
+	} else {
+		found := callGraylog(opts, fmt.Sprintf(allTimeSearch, url.QueryEscape(fieldTerm("_id", id))), jsonAcceptType)
+		index, _ = jsonparser.GetString(found, "messages", "[0]", "index")
+		if len(index) == 0 {
+			fmt.Fprintf(os.Stderr, "No message with the id '%s'\n", id)
+			os.Exit(1)
+		}
+	}
+	return index, callGraylog(opts, fmt.Sprintf(messageInfo, url.PathEscape(index), url.PathEscape(id)), jsonAcceptType)
+}
+
+// Search for the messages closest to one end of a time window.
+func searchAround(opts *options, from time.Time, to time.Time, limit int, order string) []logMessage {
+	if limit <= 0 {
+		return nil
+	}
+	searchOpts := *opts
+	searchOpts.startDate = &from
+	searchOpts.endDate = &to
+	searchOpts.limit = limit
+	api, _ := messageAPIURI(&searchOpts)
+	api += "&sort=" + url.QueryEscape("timestamp:"+order)
+	return parseMessages(callGraylog(opts, api, jsonAcceptType))
+}
+
+// Print a message of the context, marking the message the context was requested for.
+func printContextMessage(w *bytes.Buffer, opts *options, streams map[string]map[string]string, msg logMessage,
+	anchor bool) {
+	var buf bytes.Buffer
+	printMessage(&buf, opts, streams, msg)
+
+	marker := "   "
+	if anchor {
+		marker = ">> "
+		if opts.useColors {
+			marker = boldEsc + marker + resetEsc
+		}
+	}
+	scanner := bufio.NewScanner(&buf)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		w.WriteString(marker + scanner.Text() + "\n")
+	}
+}
@@ -4,27 +4,26 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
 )
 
-const debugEsc = "\033[94m"
-const errorEsc = "\033[91m"
-const infoEsc = "\033[92m"
-const resetEsc = "\033[0;0m"
-const warnEsc = "\033[93m"
-
-const boldEsc = "\033[1m"
-
+const alertLevel = "ALERT"
+const criticalLevel = "CRITICAL"
 const debugLevel = "DEBUG"
+const emergencyLevel = "EMERGENCY"
 const errorLevel = "ERROR"
 const fatalLevel = "FATAL"
 const infoLevel = "INFO"
+const noticeLevel = "NOTICE"
 const traceLevel = "TRACE"
 const warnLevel = "WARN"
 
-const longTimeFormat = "2006-01-02T15:04:05.000Z"
+const timeFormat = "15:04:05.000"
+const dateFormat = "2006-01-02"
 
 // Print a string in bold text.
 func printBoldText(text string) {
@@ -32,17 +31,27 @@ func printBoldText(text string) {
 }
 
 // Print a single log message
-func printMessage(opts *options, streamLookup map[string]map[string]string, msg logMessage) {
-	adjustMessage(msg, streamLookup, opts.noColor)
+func printMessage(w io.Writer, opts *options, streamLookup map[string]map[string]string, msg logMessage) {
+	adjustMessage(opts, streamLookup, msg)
+	if !matchesWhere(opts.where, msg) {
+		return
+	}
 
 	var text string
+	data := templateData(msg)
 
 	if opts.json {
-		buf, _ := json.Marshal(msg.fields)
+		buf, _ := json.Marshal(data)
 		text = string(buf)
+	} else if len(opts.columns) > 0 {
+		text = columnsLine(opts.columns, msg)
 	} else {
-		for _, f := range opts.serverConfig.Formats() {
-			text = tryFormat(msg, f.Name, f.Format)
+		for _, f := range opts.formats {
+			formatData := data
+			if extracted, ok := applyFormatExtractions(opts.extractions, f.Name, msg, streamLookup); ok {
+				formatData = templateData(extracted)
+			}
+			text = tryFormat(formatData, f.Name, f.Format)
 			if len(text) > 0 {
 				break
 			}
@@ -51,40 +60,92 @@ func printMessage(opts *options, streamLookup map[string]map[string]string, msg
 
 	if len(text) > 0 {
 		if strings.HasPrefix(text, "No Formats Defined>>") {
-			fmt.Println("stop")
+			fmt.Fprintln(w, "stop")
 		}
-		fmt.Println(text)
+		fmt.Fprintln(w, text)
 	} else {
 		// Last case fallback in case none of the formats (including the default) match
-		buf, _ := json.Marshal(msg.fields)
-		fmt.Println(string(buf))
+		buf, _ := json.Marshal(data)
+		fmt.Fprintln(w, string(buf))
+	}
+}
+
+// Combine the typed values of a message with its string fields, for use by format templates and the json output.
+// Nested values can be reached in templates, e.g., {{.http.status}}. Helper fields, and fields changed while adjusting
+// the message, are used as strings.
+func templateData(msg logMessage) map[string]interface{} {
+	data := make(map[string]interface{}, len(msg.fields))
+	for k, v := range msg.fields {
+		data[k] = v
+	}
+	for k, v := range msg.values {
+		switch v.(type) {
+		case string, nil:
+		default:
+			// A field rewritten while adjusting, e.g., a level number turned into its name, keeps its new value
+			if field, ok := msg.fields[k]; ok && field == valueString(v) {
+				data[k] = v
+			}
+		}
 	}
+	return data
+}
+
+// Functions available to format templates.
+var templateFuncs = template.FuncMap{
+	"ToUpper": strings.ToUpper,
+	"ToLower": strings.ToLower,
 }
 
 // Try to apply a format template.
 // returns: empty string if the format failed.
-func tryFormat(msg logMessage, tmplName string, tmpl string) string {
-	funcMap := template.FuncMap{
-		"ToUpper": strings.ToUpper,
-		"ToLower": strings.ToLower,
-	}
-	var t = template.Must(template.New(tmplName).Option("missingkey=error").Funcs(funcMap).Parse(tmpl))
+func tryFormat(data map[string]interface{}, tmplName string, tmpl string) string {
+	var t = template.Must(template.New(tmplName).Option("missingkey=error").Funcs(templateFuncs).Parse(tmpl))
 	var result bytes.Buffer
 
-	if err := t.Execute(&result, msg.fields); err == nil {
+	if err := t.Execute(&result, data); err == nil {
 		return result.String()
 	}
 	return ""
 }
 
-// Convert a timestamp to a long time string.
-func longTime(t time.Time) string {
-	t = t.In(time.Local)
-	return t.Format(longTimeFormat)
+// Convert a timestamp to a long time string in the given location.
+func longTime(t time.Time, loc *time.Location, layout string) string {
+	return t.In(loc).Format(layout)
+}
+
+// Describe how long ago a timestamp was, e.g., "5m ago".
+func relativeTime(t time.Time, now time.Time) string {
+	d := now.Sub(t)
+	suffix := " ago"
+	if d < 0 {
+		d = -d
+		suffix = " from now"
+	}
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds%s", int(d.Seconds()), suffix)
+	case d < time.Hour:
+		return fmt.Sprintf("%dm%s", int(d.Minutes()), suffix)
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh%s", int(d.Hours()), suffix)
+	default:
+		return fmt.Sprintf("%dd%s", int(d.Hours()/24), suffix)
+	}
+}
+
+// Add the timestamp helper fields.
+func addTimeFields(msg logMessage, loc *time.Location, layout string) {
+	timestamp := msg.timestamp
+	msg.fields[longTimestampField] = longTime(timestamp, loc, layout)
+	msg.fields[timeField] = timestamp.In(loc).Format(timeFormat)
+	msg.fields[dateField] = timestamp.In(loc).Format(dateFormat)
+	msg.fields[epochMillisField] = strconv.FormatInt(timestamp.UnixNano()/int64(time.Millisecond), 10)
+	msg.fields[relativeTimeField] = relativeTime(timestamp, time.Now())
 }
 
 // "Cleanup" the log message and add helper fields.
-func adjustMessage(msg logMessage, streamLookup map[string]map[string]string, isTty bool) {
+func adjustMessage(opts *options, streamLookup map[string]map[string]string, msg logMessage) {
 	requestPage := msg.fields[requestPageField]
 	if len(requestPage) > 1 && !strings.HasPrefix(requestPage, "/") {
 		msg.fields[requestPageField] = "/" + requestPage
@@ -96,23 +157,18 @@ func adjustMessage(msg logMessage, streamLookup map[string]map[string]string, is
 		msg.fields[originalMessageField] = originalMessage
 	}
 
-	timestamp := msg.timestamp
-	msg.fields[longTimestampField] = longTime(timestamp)
+	addTimeFields(msg, opts.location, opts.timeFormat)
 
 	classname := msg.fields[classnameField]
 	if len(classname) > 0 {
 		msg.fields[shortClassnameField] = createShortClassname(classname)
 	}
 
-	constructMessageText(msg, originalMessage)
+	applyExtractions(opts.extractions, msg, streamLookup)
 
-	level := normalizeLevel(msg)
+	constructMessageText(msg, originalMessage, opts.traces)
 
-	if isTty {
-		computeLogLevelColor(level, msg)
-	} else {
-		emptyLogLevelColor(msg)
-	}
+	level := normalizeLevel(msg)
 
 	if len(msg.streams) > 0 {
 		var streamNames []string
@@ -123,11 +179,21 @@ func adjustMessage(msg logMessage, streamLookup map[string]map[string]string, is
 		streamDisplay := strings.Join(streamNames, " ")
 		msg.fields[matchingStreamsField] = streamDisplay
 	}
+
+	applyExtractors(opts.extractors, msg)
+
+	if opts.useColors {
+		computeLogLevelColor(opts.colors, level, msg)
+		computeFieldColors(opts.colors, msg)
+	} else {
+		emptyLogLevelColor(msg)
+		emptyFieldColors(opts.colors, msg)
+	}
 }
 
 // Construct the "best" version of the log messages main text. This will look in multiple fields, attempt to
-// append multi-line text (stacktraces) onto the message text, etc.
-func constructMessageText(msg logMessage, originalMessage string) {
+// append multi-line text (stacktraces) onto the message text, fold the stacktraces, etc.
+func constructMessageText(msg logMessage, originalMessage string, traces *traceFolding) {
 	const nestedException = "; nested exception "
 	const newlineNnestedException = ";\nnested exception "
 
@@ -147,7 +213,7 @@ func constructMessageText(msg logMessage, originalMessage string) {
 			messageText = messageText + "\n" + strings.Join(extraInfo[1:len(extraInfo)-1], "\n")
 		}
 	}
-	msg.fields[messageTextField] = messageText
+	msg.fields[messageTextField] = foldStacktraces(messageText, traces)
 }
 
 // Normalize the "level" of the message.
@@ -165,18 +231,8 @@ func normalizeLevel(msg logMessage) string {
 }
 
 // Compute the color that should be used to display the log level in the message output.
-func computeLogLevelColor(level string, msg logMessage) {
-	var levelColor string
-	switch level {
-	case debugLevel, traceLevel:
-		levelColor = debugEsc
-	case infoLevel:
-		levelColor = infoEsc
-	case warnLevel:
-		levelColor = warnEsc
-	case errorLevel, fatalLevel:
-		levelColor = errorEsc
-	}
+func computeLogLevelColor(colors *colorScheme, level string, msg logMessage) {
+	levelColor := colors.levelColor(level)
 	if len(levelColor) > 0 {
 		msg.fields[levelColorField] = levelColor
 		msg.fields[resetField] = resetEsc
@@ -191,6 +247,24 @@ func emptyLogLevelColor(msg logMessage) {
 	msg.fields[resetField] = ""
 }
 
+// Compute the colors of the fields configured in the [colors] section, e.g., _color_source for the source field.
+func computeFieldColors(colors *colorScheme, msg logMessage) {
+	for _, f := range colors.fields {
+		esc := f.color(msg.fields[f.name])
+		msg.fields[colorFieldPrefix+f.name] = esc
+		if len(esc) > 0 {
+			msg.fields[resetField] = resetEsc
+		}
+	}
+}
+
+// Replace the field color strings with empty strings so templates referencing them still apply.
+func emptyFieldColors(colors *colorScheme, msg logMessage) {
+	for _, f := range colors.fields {
+		msg.fields[colorFieldPrefix+f.name] = ""
+	}
+}
+
 // Create a shortened version of the Java classname.
 func createShortClassname(classname string) string {
 	parts := strings.Split(classname, ".")
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultExportPath is the file exported messages are written to when --out isn't given.
+const DefaultExportPath = "export.csv"
+
+// Export destination that means stdout.
+const stdoutPath = "-"
+
+// Export formats. CSV exports are produced by Graylog, unless they're filtered with --where, the others are written by
+// the client.
+const csvFormat = "csv"
+const ndjsonFormat = "ndjson"
+const sqliteFormat = "sqlite"
+const parquetFormat = "parquet"
+
+// Number of messages requested from Graylog at a time for client-side exports.
+const exportPageSize = 1000
+
+// exportWriter writes messages into a client-side export format.
+type exportWriter interface {
+	write(msg logMessage) error
+	// The number of bytes written, before any compression.
+	size() int64
+	Close() error
+}
+
+// Counts the bytes and CSV records written through it. Newlines inside quoted values don't end a record.
+type countingWriter struct {
+	w        io.Writer
+	bytes    int64
+	records  int64
+	inQuotes bool
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	for _, b := range p[:n] {
+		switch b {
+		case '"':
+			c.inQuotes = !c.inQuotes
+		case '\n':
+			if !c.inQuotes {
+				c.records++
+			}
+		}
+	}
+	c.bytes += int64(n)
+	return n, err
+}
+
+// Closes both the gzip stream and the file underneath it.
+type gzipFile struct {
+	*gzip.Writer
+	file *os.File
+}
+
+func (g *gzipFile) Close() error {
+	err := g.Writer.Close()
+	if err2 := g.file.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Nothing to close when writing to stdout.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// Remove an existing export file when force is set, otherwise refuse to replace it.
+func checkOverwrite(path string, force bool) error {
+	if _, err := os.Stat(path); err == nil {
+		if !force {
+			return fmt.Errorf("'%s' already exists, use --force to overwrite it", path)
+		}
+		return os.Remove(path)
+	}
+	return nil
+}
+
+// Open the export destination. "-" writes to stdout, files ending in .gz are gzip compressed. Existing files are only
+// replaced when force is set.
+func createExportWriter(path string, force bool) (io.WriteCloser, error) {
+	if path == stdoutPath {
+		return nopCloser{os.Stdout}, nil
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("'%s' already exists, use --force to overwrite it", path)
+		}
+		return nil, err
+	}
+
+	if strings.HasSuffix(strings.ToLower(path), ".gz") {
+		return &gzipFile{Writer: gzip.NewWriter(f), file: f}, nil
+	}
+	return f, nil
+}
+
+// Stream an export from Graylog into the destination chosen with --out and report a summary on stderr.
+func exportToFile(opts *options, body io.Reader) {
+	start := time.Now()
+
+	out, err := createExportWriter(opts.exportPath, opts.force)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write to '%s': %s\n", opts.exportPath, err.Error())
+		os.Exit(1)
+	}
+
+	counter := &countingWriter{w: out}
+	_, err = io.Copy(counter, body)
+	if err2 := out.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to export to '%s': %s\n", opts.exportPath, err.Error())
+		os.Exit(1)
+	}
+
+	// The first record is the header
+	rows := counter.records - 1
+	if rows < 0 {
+		rows = 0
+	}
+	printExportSummary(opts, rows, counter.bytes, start)
+}
+
+// Page through the search results and write them with the client-side writer for the export format.
+func exportMessages(opts *options) {
+	start := time.Now()
+	fmt.Fprintln(os.Stderr, "Exporting...")
+
+	// Exports never contain color escapes
+	exportOpts := *opts
+	exportOpts.useColors = false
+
+	out, err := newExportWriter(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write to '%s': %s\n", opts.exportPath, err.Error())
+		os.Exit(1)
+	}
+
+	var rows int64
+	err = pageMessages(&exportOpts, func(messages []logMessage) error {
+		for _, msg := range messages {
+			if err := out.write(msg); err != nil {
+				return err
+			}
+			rows++
+		}
+		return nil
+	})
+	if err2 := out.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to export to '%s': %s\n", opts.exportPath, err.Error())
+		os.Exit(1)
+	}
+
+	printExportSummary(opts, rows, out.size(), start)
+}
+
+// Page through the search results oldest first, handing each page of adjusted messages that pass the --where
+// expressions to the callback. Elasticsearch refuses offsets past its result window (10,000 messages by default), so
+// each page starts at the timestamp of the last message of the previous one, skipping the messages of that timestamp
+// already handed over.
+func pageMessages(opts *options, callback func([]logMessage) error) error {
+	streams := fetchStreams(opts)
+	pageOpts := *opts
+	from := *opts.startDate
+	pageOpts.startDate = &from
+	skip := 0
+	for {
+		api, _ := messageAPIURI(&pageOpts)
+		page := fmt.Sprintf("%s&limit=%d&offset=%d&sort=%s", api, exportPageSize, skip, url.QueryEscape("timestamp:asc"))
+		body, err := tryCallGraylog(opts, page)
+		if err != nil {
+			return err
+		}
+		messages := parseMessages(body)
+		if len(messages) == 0 {
+			return nil
+		}
+
+		last := messages[len(messages)-1].timestamp
+		if !last.Equal(from) {
+			from, skip = last, 0
+		}
+		for _, msg := range messages {
+			if msg.timestamp.Equal(last) {
+				skip++
+			}
+			adjustMessage(opts, streams, msg)
+		}
+		if err := callback(filterWhere(opts.where, messages)); err != nil {
+			return err
+		}
+		if len(messages) < exportPageSize {
+			return nil
+		}
+	}
+}
+
+// Create the client-side writer for the export format.
+func newExportWriter(opts *options) (exportWriter, error) {
+	fields := exportFields(opts.fields)
+	switch opts.exportFormat {
+	case sqliteFormat:
+		return newSQLiteWriter(opts.exportPath, opts.force, fields)
+	case parquetFormat:
+		return newParquetWriter(opts.exportPath, opts.force, fields)
+	case csvFormat:
+		return newCSVWriter(opts.exportPath, opts.force, fields)
+	default:
+		return newNDJSONWriter(opts.exportPath, opts.force, fields)
+	}
+}
+
+// Pick the export format from the --out file extension. Defaults to CSV.
+func exportFormatFromPath(path string) string {
+	path = strings.TrimSuffix(strings.ToLower(path), ".gz")
+	switch filepath.Ext(path) {
+	case ".ndjson", ".jsonl", ".json":
+		return ndjsonFormat
+	case ".sqlite", ".sqlite3", ".db":
+		return sqliteFormat
+	case ".parquet":
+		return parquetFormat
+	}
+	return csvFormat
+}
+
+// Only text exports can be gzip compressed: Parquet compresses its columns itself, and SQLite writes into the file in
+// place.
+func checkExportCompression(format string, path string) error {
+	if (format == sqliteFormat || format == parquetFormat) && strings.HasSuffix(strings.ToLower(path), ".gz") {
+		return fmt.Errorf("%s exports can't be gzip compressed, drop the .gz from '%s'", format, path)
+	}
+	return nil
+}
+
+// Split the --export field list into field names.
+func exportFields(fieldList string) (fields []string) {
+	for _, f := range strings.Split(fieldList, ",") {
+		f = strings.TrimSpace(f)
+		if len(f) > 0 {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// Report the rows and bytes written by an export on stderr.
+func printExportSummary(opts *options, rows int64, size int64, start time.Time) {
+	destination := "stdout"
+	if opts.exportPath != stdoutPath {
+		destination, _ = filepath.Abs(opts.exportPath)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d rows (%s) to %s in %s\n",
+		rows, formatBytes(size), destination, time.Since(start).Round(time.Millisecond))
+}
+
+// Writes one JSON object per line, with the fields in the order they were requested.
+type ndjsonWriter struct {
+	out     io.WriteCloser
+	counter *countingWriter
+	buf     *bufio.Writer
+	fields  []string
+}
+
+func newNDJSONWriter(path string, force bool, fields []string) (exportWriter, error) {
+	out, err := createExportWriter(path, force)
+	if err != nil {
+		return nil, err
+	}
+	counter := &countingWriter{w: out}
+	return &ndjsonWriter{out: out, counter: counter, buf: bufio.NewWriter(counter), fields: fields}, nil
+}
+
+func (w *ndjsonWriter) write(msg logMessage) error {
+	_, err := w.buf.Write(ndjsonLine(w.fields, msg))
+	return err
+}
+
+// Render a message as a single line JSON object with the given fields. Values keep their types.
+func ndjsonLine(fields []string, msg logMessage) []byte {
+	data := templateData(msg)
+	var line []byte
+	line = append(line, '{')
+	for i, f := range fields {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		key, _ := json.Marshal(f)
+		line = append(line, key...)
+		line = append(line, ':')
+		if value, ok := data[f]; ok {
+			v, _ := json.Marshal(value)
+			line = append(line, v...)
+		} else {
+			line = append(line, "null"...)
+		}
+	}
+	line = append(line, '}', '\n')
+	return line
+}
+
+func (w *ndjsonWriter) size() int64 {
+	return w.counter.bytes
+}
+
+func (w *ndjsonWriter) Close() error {
+	err := w.buf.Flush()
+	if err2 := w.out.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Writes a CSV file with a header row, for exports that can't be produced by Graylog.
+type csvWriter struct {
+	out     io.WriteCloser
+	counter *countingWriter
+	csv     *csv.Writer
+	fields  []string
+}
+
+func newCSVWriter(path string, force bool, fields []string) (exportWriter, error) {
+	out, err := createExportWriter(path, force)
+	if err != nil {
+		return nil, err
+	}
+	counter := &countingWriter{w: out}
+	w := &csvWriter{out: out, counter: counter, csv: csv.NewWriter(counter), fields: fields}
+	return w, w.csv.Write(fields)
+}
+
+func (w *csvWriter) write(msg logMessage) error {
+	return w.csv.Write(csvRecord(w.fields, msg))
+}
+
+// The values of the fields of a message, in the order they were requested.
+func csvRecord(fields []string, msg logMessage) []string {
+	record := make([]string, len(fields))
+	for i, f := range fields {
+		record[i] = msg.fields[f]
+	}
+	return record
+}
+
+func (w *csvWriter) size() int64 {
+	return w.counter.bytes
+}
+
+func (w *csvWriter) Close() error {
+	w.csv.Flush()
+	err := w.csv.Error()
+	if err2 := w.out.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Format a byte count for humans, e.g., 1.5 MB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
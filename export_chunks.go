@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Suffix of the file that records the progress of a chunked export.
+const checkpointSuffix = ".checkpoint"
+
+// A single time window of a chunked export.
+type exportChunk struct {
+	from time.Time
+	to   time.Time
+}
+
+// The fetched contents of a chunk. CSV chunks are downloaded into a temporary file, the other formats are kept as
+// messages.
+type chunkResult struct {
+	csv      *os.File
+	messages []logMessage
+	err      error
+}
+
+// exportCheckpoint records the progress of a chunked export so it can be resumed.
+type exportCheckpoint struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	ChunkSecs int       `json:"chunkSeconds"`
+	Query     string    `json:"query"`
+	Streams   []string  `json:"streams"`
+	Fields    string    `json:"fields"`
+	Format    string    `json:"format"`
+	Completed int       `json:"completedChunks"`
+	Rows      int64     `json:"rows"`
+	Size      int64     `json:"size"`
+}
+
+// chunkSink writes the chunks of an export to the destination, in order.
+type chunkSink interface {
+	writeChunk(result chunkResult, first bool) (rows int64, err error)
+	size() int64
+	Close() error
+}
+
+// Split the time range into consecutive windows of the given size. The last window may be shorter.
+func splitTimeRange(start time.Time, end time.Time, size time.Duration) (chunks []exportChunk) {
+	for from := start; from.Before(end); from = from.Add(size) {
+		to := from.Add(size)
+		if to.After(end) {
+			to = end
+		}
+		chunks = append(chunks, exportChunk{from: from, to: to})
+	}
+	return chunks
+}
+
+// Export the search results one time chunk at a time. Chunks are fetched with up to --parallel requests at once,
+// written in order, and the progress is recorded in a checkpoint file next to the export so --resume can pick up
+// after the last completed chunk.
+func exportChunked(opts *options) {
+	startTime := time.Now()
+	checkpointPath := opts.exportPath + checkpointSuffix
+	checkpoint := exportCheckpoint{
+		Start:     *opts.startDate,
+		End:       *opts.endDate,
+		ChunkSecs: opts.exportChunk,
+		Query:     opts.query,
+		Streams:   opts.streamIds,
+		Fields:    opts.fields,
+		Format:    opts.exportFormat,
+	}
+
+	if opts.resume {
+		saved, err := readCheckpoint(checkpointPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to resume export: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if saved.Query != checkpoint.Query || saved.Fields != checkpoint.Fields || saved.Format != checkpoint.Format ||
+			strings.Join(saved.Streams, ",") != strings.Join(checkpoint.Streams, ",") {
+			fmt.Fprintf(os.Stderr, "The checkpoint in '%s' is for a different export. Remove it or drop --resume.\n", checkpointPath)
+			os.Exit(1)
+		}
+		// The checkpoint's time range wins, so relative start times still resume the same export
+		checkpoint = *saved
+	}
+
+	chunks := splitTimeRange(checkpoint.Start, checkpoint.End, time.Duration(checkpoint.ChunkSecs)*time.Second)
+	if checkpoint.Completed >= len(chunks) {
+		fmt.Fprintln(os.Stderr, "The export is already complete")
+		_ = os.Remove(checkpointPath)
+		return
+	}
+	sink, err := newChunkSink(opts, &checkpoint, chunks[checkpoint.Completed].from)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write to '%s': %s\n", opts.exportPath, err.Error())
+		os.Exit(1)
+	}
+	// Record the export before its first chunk, so it can be resumed even if interrupted during that chunk
+	if opts.exportPath != stdoutPath && checkpoint.Completed == 0 {
+		if err := writeCheckpoint(checkpointPath, &checkpoint); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write checkpoint '%s': %s\n", checkpointPath, err.Error())
+		}
+	}
+
+	if err := writeChunks(opts, sink, &checkpoint, checkpointPath, chunks); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to export to '%s': %s\n", opts.exportPath, err.Error())
+		os.Exit(1)
+	}
+
+	if err := sink.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to export to '%s': %s\n", opts.exportPath, err.Error())
+		os.Exit(1)
+	}
+	_ = os.Remove(checkpointPath)
+
+	size := sink.size()
+	if info, err := os.Stat(opts.exportPath); err == nil {
+		size = info.Size()
+	}
+	printExportSummary(opts, checkpoint.Rows, size, startTime)
+}
+
+// Fetch and write the chunks after the last completed one, recording each in the checkpoint. A chunk that can't be
+// fetched stops the export before it's recorded, so --resume fetches it again.
+func writeChunks(opts *options, sink chunkSink, checkpoint *exportCheckpoint, checkpointPath string, chunks []exportChunk) error {
+	remaining := chunks[checkpoint.Completed:]
+	// Exports never contain color escapes
+	exportOpts := *opts
+	exportOpts.useColors = false
+	// Load the stream cache up front, the chunks are fetched concurrently
+	fetchStreams(opts)
+
+	parallel := opts.parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	results := make([]chan chunkResult, len(remaining))
+	for i := range results {
+		results[i] = make(chan chunkResult, 1)
+	}
+	startFetch := func(i int) {
+		go func() {
+			results[i] <- fetchChunk(&exportOpts, remaining[i])
+		}()
+	}
+	for i := 0; i < parallel && i < len(remaining); i++ {
+		startFetch(i)
+	}
+
+	for i, chunk := range remaining {
+		result := <-results[i]
+		if next := i + parallel; next < len(remaining) {
+			startFetch(next)
+		}
+
+		if result.err != nil {
+			return fmt.Errorf("the chunk starting %s failed, run the same command with --resume to continue: %s",
+				chunk.from.Format(graylogInputTimeFormat), result.err.Error())
+		}
+		rows, err := sink.writeChunk(result, checkpoint.Completed == 0)
+		if err != nil {
+			return err
+		}
+		checkpoint.Completed++
+		checkpoint.Rows += rows
+		checkpoint.Size = sink.size()
+		if opts.exportPath != stdoutPath {
+			if err := writeCheckpoint(checkpointPath, checkpoint); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to write checkpoint '%s': %s\n", checkpointPath, err.Error())
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Exported chunk %d/%d (%s - %s): %d rows\n", checkpoint.Completed, len(chunks),
+			chunk.from.Format(graylogInputTimeFormat), chunk.to.Format(graylogInputTimeFormat), rows)
+	}
+	return nil
+}
+
+// The options of the search of a chunk.
+func chunkOptions(opts *options, chunk exportChunk) options {
+	chunkOpts := *opts
+	from, to := chunk.from, chunk.to.Add(-time.Millisecond)
+	chunkOpts.startDate = &from
+	chunkOpts.endDate = &to
+	return chunkOpts
+}
+
+// Fetch the contents of a single chunk. Each chunk ends a millisecond before the next one starts, so boundary
+// messages aren't exported twice.
+func fetchChunk(opts *options, chunk exportChunk) (result chunkResult) {
+	chunkOpts := chunkOptions(opts, chunk)
+	api, _ := messageAPIURI(&chunkOpts)
+
+	if opts.exportFormat != csvFormat || len(opts.where) > 0 {
+		result.err = pageMessages(&chunkOpts, func(messages []logMessage) error {
+			result.messages = append(result.messages, messages...)
+			return nil
+		})
+		return result
+	}
+
+	cfg := opts.serverConfig
+	resp := connect(cfg.Uri()+"/"+api, cfg.Username(), cfg.Password(), cfg.IgnoreCert(), csvAcceptType)
+	//noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		result.err = fmt.Errorf("Graylog answered '%s'", resp.Status)
+		return result
+	}
+
+	f, err := ioutil.TempFile(exportDir(opts.exportPath), ".graylog-chunk-")
+	if err == nil {
+		// The file is only needed until it's been copied into the export
+		_ = os.Remove(f.Name())
+		_, err = io.Copy(f, resp.Body)
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		result.err = err
+		return result
+	}
+	result.csv = f
+	return result
+}
+
+// Directory used for temporary chunk files. Keeping them next to the export avoids filling up a small /tmp.
+func exportDir(path string) string {
+	if path == stdoutPath {
+		return ""
+	}
+	return filepath.Dir(path)
+}
+
+// Create the destination of a chunked export. When resuming, the destination is reopened and anything written after
+// the last completed chunk is discarded.
+func newChunkSink(opts *options, checkpoint *exportCheckpoint, from time.Time) (chunkSink, error) {
+	fields := exportFields(opts.fields)
+	// With --resume a checkpoint was found, even one with no completed chunk means the export already started
+	resuming := opts.resume
+
+	switch opts.exportFormat {
+	case sqliteFormat:
+		if resuming {
+			w, err := resumeSQLiteWriter(opts.exportPath, fields, from)
+			if err != nil {
+				return nil, err
+			}
+			return &recordSink{writer: w, path: opts.exportPath}, nil
+		}
+		w, err := newSQLiteWriter(opts.exportPath, opts.force, fields)
+		if err != nil {
+			return nil, err
+		}
+		return &recordSink{writer: w, path: opts.exportPath}, nil
+	case parquetFormat:
+		if resuming && checkpoint.Completed > 0 {
+			return nil, fmt.Errorf("Parquet exports can't be resumed")
+		}
+		w, err := newParquetWriter(opts.exportPath, opts.force || resuming, fields)
+		if err != nil {
+			return nil, err
+		}
+		return &recordSink{writer: w, path: opts.exportPath}, nil
+	}
+
+	sink := &textSink{fields: fields, csv: opts.exportFormat == csvFormat, gzip: strings.HasSuffix(strings.ToLower(opts.exportPath), ".gz")}
+	switch {
+	case opts.exportPath == stdoutPath:
+		sink.out = os.Stdout
+	case resuming:
+		f, err := os.OpenFile(opts.exportPath, os.O_WRONLY, 0644)
+		if err == nil {
+			err = f.Truncate(checkpoint.Size)
+		}
+		if err == nil {
+			sink.written, err = f.Seek(0, io.SeekEnd)
+		}
+		if err != nil {
+			return nil, err
+		}
+		sink.out = f
+	default:
+		if err := checkOverwrite(opts.exportPath, opts.force); err != nil {
+			return nil, err
+		}
+		f, err := os.Create(opts.exportPath)
+		if err != nil {
+			return nil, err
+		}
+		sink.out = f
+	}
+	return sink, nil
+}
+
+// Writes CSV and NDJSON chunks. Compressed exports get a gzip member per chunk, so a resumed export can be
+// truncated back to the last completed chunk.
+type textSink struct {
+	out     *os.File
+	fields  []string
+	csv     bool
+	gzip    bool
+	written int64
+}
+
+func (s *textSink) writeChunk(result chunkResult, first bool) (int64, error) {
+	counter := &countingWriter{w: s.out}
+	var w io.Writer = counter
+	var zw *gzip.Writer
+	if s.gzip {
+		zw = gzip.NewWriter(counter)
+		w = zw
+	}
+
+	var rows int64
+	var err error
+	if result.csv != nil {
+		//noinspection GoUnhandledErrorResult
+		defer result.csv.Close()
+		rows, err = copyCSVChunk(w, result.csv, first)
+	} else if s.csv {
+		cw := csv.NewWriter(w)
+		if first {
+			err = cw.Write(s.fields)
+		}
+		for _, msg := range result.messages {
+			if err != nil {
+				break
+			}
+			err = cw.Write(csvRecord(s.fields, msg))
+			rows++
+		}
+		cw.Flush()
+		if err == nil {
+			err = cw.Error()
+		}
+	} else {
+		buf := bufio.NewWriter(w)
+		for _, msg := range result.messages {
+			if _, err = buf.Write(ndjsonLine(s.fields, msg)); err != nil {
+				break
+			}
+			rows++
+		}
+		if err == nil {
+			err = buf.Flush()
+		}
+	}
+	if zw != nil {
+		if err2 := zw.Close(); err == nil {
+			err = err2
+		}
+	}
+	s.written += counter.bytes
+	return rows, err
+}
+
+func (s *textSink) size() int64 {
+	return s.written
+}
+
+func (s *textSink) Close() error {
+	if s.out == os.Stdout {
+		return nil
+	}
+	return s.out.Close()
+}
+
+// Copy a CSV chunk, dropping its header unless it's the first chunk of the export.
+// returns: the number of rows copied.
+func copyCSVChunk(w io.Writer, r io.Reader, keepHeader bool) (int64, error) {
+	reader := bufio.NewReader(r)
+	if !keepHeader {
+		// The header ends at the first newline outside of quotes
+		inQuotes := false
+		for {
+			b, err := reader.ReadByte()
+			if err == io.EOF {
+				return 0, nil
+			}
+			if err != nil {
+				return 0, err
+			}
+			if b == '"' {
+				inQuotes = !inQuotes
+			} else if b == '\n' && !inQuotes {
+				break
+			}
+		}
+	}
+
+	counter := &countingWriter{w: w}
+	if _, err := io.Copy(counter, reader); err != nil {
+		return 0, err
+	}
+	rows := counter.records
+	if keepHeader && rows > 0 {
+		rows--
+	}
+	return rows, nil
+}
+
+// Writes SQLite and Parquet chunks through their export writer.
+type recordSink struct {
+	writer exportWriter
+	path   string
+}
+
+func (s *recordSink) writeChunk(result chunkResult, first bool) (int64, error) {
+	var rows int64
+	for _, msg := range result.messages {
+		if err := s.writer.write(msg); err != nil {
+			return rows, err
+		}
+		rows++
+	}
+	if w, ok := s.writer.(*sqliteWriter); ok {
+		return rows, w.commit()
+	}
+	return rows, nil
+}
+
+func (s *recordSink) size() int64 {
+	if info, err := os.Stat(s.path); err == nil {
+		return info.Size()
+	}
+	return 0
+}
+
+func (s *recordSink) Close() error {
+	return s.writer.Close()
+}
+
+// Read the checkpoint of an interrupted export.
+func readCheckpoint(path string) (*exportCheckpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("no checkpoint found at '%s'", path)
+	}
+	var checkpoint exportCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("checkpoint '%s' can't be parsed: %s", path, err.Error())
+	}
+	return &checkpoint, nil
+}
+
+// Save the checkpoint. It's written to a temporary file first so an interruption can't leave a partial checkpoint.
+func writeCheckpoint(path string, checkpoint *exportCheckpoint) error {
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
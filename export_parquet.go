@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Number of rows buffered before they're handed to the Parquet writer.
+const parquetBatchSize = 1000
+
+// Writes messages into a Parquet file. The timestamp column is a millisecond timestamp, the requested fields are
+// optional string columns.
+type parquetWriter struct {
+	out     io.WriteCloser
+	counter *countingWriter
+	writer  *parquet.Writer
+	columns []string
+	rows    []parquet.Row
+}
+
+func newParquetWriter(path string, force bool, fields []string) (exportWriter, error) {
+	if path == stdoutPath {
+		return nil, fmt.Errorf("Parquet exports can't be written to stdout")
+	}
+
+	group := parquet.Group{timestampField: parquet.Timestamp(parquet.Millisecond)}
+	for _, f := range fields {
+		if f != timestampField {
+			group[f] = parquet.Optional(parquet.String())
+		}
+	}
+	schema := parquet.NewSchema(sqliteTable, group)
+
+	out, err := createExportWriter(path, force)
+	if err != nil {
+		return nil, err
+	}
+
+	// The schema orders the columns by name, rows have to follow that order.
+	var columns []string
+	for _, column := range schema.Columns() {
+		columns = append(columns, column[0])
+	}
+
+	counter := &countingWriter{w: out}
+	return &parquetWriter{out: out, counter: counter, writer: parquet.NewWriter(counter, schema), columns: columns}, nil
+}
+
+func (w *parquetWriter) write(msg logMessage) error {
+	row := make(parquet.Row, len(w.columns))
+	for i, column := range w.columns {
+		if column == timestampField {
+			row[i] = parquet.Int64Value(msg.timestamp.UnixNano()/1e6).Level(0, 0, i)
+		} else if value, ok := msg.fields[column]; ok {
+			row[i] = parquet.ByteArrayValue([]byte(value)).Level(0, 1, i)
+		} else {
+			row[i] = parquet.NullValue().Level(0, 0, i)
+		}
+	}
+	w.rows = append(w.rows, row)
+
+	if len(w.rows) >= parquetBatchSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// Hand the buffered rows to the Parquet writer.
+func (w *parquetWriter) flush() error {
+	_, err := w.writer.WriteRows(w.rows)
+	w.rows = w.rows[:0]
+	return err
+}
+
+func (w *parquetWriter) size() int64 {
+	return w.counter.bytes
+}
+
+func (w *parquetWriter) Close() error {
+	err := w.flush()
+	if err2 := w.writer.Close(); err == nil {
+		err = err2
+	}
+	if err2 := w.out.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
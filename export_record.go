@@ -0,0 +1,173 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultRotateSize is the size at which the record file is rotated when no --rotate-size is given.
+const DefaultRotateSize = "100MB"
+
+// Layout of the timestamp added to the name of rotated record files.
+const rotatedTimeFormat = "20060102T150405"
+
+// recorder appends the selected fields of tailed messages to the export file, rotating it when it grows too large.
+// Only the CSV and NDJSON formats can be recorded.
+type recorder struct {
+	path    string
+	format  string
+	fields  []string
+	maxSize int64
+	file    *os.File
+	size    int64
+}
+
+// Create the recorder for the record mode (--tail with --export).
+func newRecorder(opts *options) (*recorder, error) {
+	if opts.exportPath == stdoutPath {
+		return nil, fmt.Errorf("recording requires an --out file")
+	}
+	if opts.exportFormat != csvFormat && opts.exportFormat != ndjsonFormat {
+		return nil, fmt.Errorf("only the csv and ndjson formats can be recorded")
+	}
+	r := &recorder{
+		path:    opts.exportPath,
+		format:  opts.exportFormat,
+		fields:  exportFields(opts.fields),
+		maxSize: opts.rotateSize,
+	}
+	return r, r.open()
+}
+
+// Open the record file for appending.
+func (r *recorder) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	r.file = f
+	r.size = info.Size()
+	return nil
+}
+
+// Append the messages to the record file. Compressed files get a gzip member per batch of messages.
+func (r *recorder) record(opts *options, streams map[string]map[string]string, messages []logMessage) error {
+	// Exports never contain color escapes
+	exportOpts := *opts
+	exportOpts.useColors = false
+
+	for _, msg := range messages {
+		adjustMessage(&exportOpts, streams, msg)
+	}
+	messages = filterWhere(opts.where, messages)
+	if len(messages) == 0 {
+		return nil
+	}
+
+	counter := &countingWriter{w: r.file}
+	var w io.Writer = counter
+	var zw *gzip.Writer
+	if strings.HasSuffix(strings.ToLower(r.path), ".gz") {
+		zw = gzip.NewWriter(counter)
+		w = zw
+	}
+
+	var err error
+	if r.format == ndjsonFormat {
+		for _, msg := range messages {
+			if _, err = w.Write(ndjsonLine(r.fields, msg)); err != nil {
+				break
+			}
+		}
+	} else {
+		cw := csv.NewWriter(w)
+		if r.size == 0 {
+			_ = cw.Write(r.fields)
+		}
+		for _, msg := range messages {
+			if err = cw.Write(csvRecord(r.fields, msg)); err != nil {
+				break
+			}
+		}
+		cw.Flush()
+		if err == nil {
+			err = cw.Error()
+		}
+	}
+	if zw != nil {
+		if err2 := zw.Close(); err == nil {
+			err = err2
+		}
+	}
+	r.size += counter.bytes
+	if err != nil {
+		return err
+	}
+
+	if r.maxSize > 0 && r.size >= r.maxSize {
+		return r.rotate(time.Now())
+	}
+	return nil
+}
+
+// Move the current record file aside, e.g., export.csv to export-20190601T120000.csv, and start a new one.
+func (r *recorder) rotate(now time.Time) error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(r.path, rotatedName(r.path, now)); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+// Close the record file.
+func (r *recorder) Close() error {
+	return r.file.Close()
+}
+
+// Name of a rotated record file. The timestamp goes before the extension(s) so the file keeps its type.
+func rotatedName(path string, now time.Time) string {
+	dir, name := filepath.Split(path)
+	ext := ""
+	if strings.HasSuffix(strings.ToLower(name), ".gz") {
+		ext = name[len(name)-3:]
+		name = name[:len(name)-3]
+	}
+	ext = filepath.Ext(name) + ext
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	return filepath.Join(dir, name+"-"+now.Format(rotatedTimeFormat)+ext)
+}
+
+// Convert a human-friendly size, e.g., 500KB, 100MB or 1GB, into bytes.
+func parseSize(size string) (int64, error) {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	i := strings.IndexFunc(size, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+	number, unit := size, ""
+	if i >= 0 {
+		number, unit = size[:i], strings.TrimSpace(size[i:])
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s'", size)
+	}
+	multipliers := map[string]float64{"": 1, "B": 1, "K": 1 << 10, "KB": 1 << 10, "M": 1 << 20, "MB": 1 << 20, "G": 1 << 30, "GB": 1 << 30}
+	m, ok := multipliers[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit '%s'", unit)
+	}
+	return int64(n * m), nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Name of the table messages are exported into.
+const sqliteTable = "messages"
+
+// Number of rows inserted per transaction.
+const sqliteBatchSize = 1000
+
+// Fixed width, so timestamps sort correctly as text.
+const sqliteTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// Writes messages into a SQLite database, one row per message with the requested fields as TEXT columns. The
+// timestamp column is always present (ISO-8601, UTC) and indexed.
+type sqliteWriter struct {
+	path    string
+	db      *sql.DB
+	tx      *sql.Tx
+	insert  *sql.Stmt
+	fields  []string
+	pending int
+}
+
+func newSQLiteWriter(path string, force bool, fields []string) (exportWriter, error) {
+	if path == stdoutPath {
+		return nil, fmt.Errorf("SQLite exports can't be written to stdout")
+	}
+	if err := checkOverwrite(path, force); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	columnFields := sqliteColumnFields(fields)
+	columns := []string{quoteIdentifier(timestampField) + " TEXT NOT NULL"}
+	for _, f := range columnFields {
+		columns = append(columns, quoteIdentifier(f)+" TEXT")
+	}
+
+	create := fmt.Sprintf("CREATE TABLE %s (%s); CREATE INDEX %s ON %s (%s);",
+		sqliteTable, strings.Join(columns, ", "),
+		quoteIdentifier(sqliteTable+"_"+timestampField), sqliteTable, quoteIdentifier(timestampField))
+	if _, err = db.Exec(create); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	w := &sqliteWriter{path: path, db: db, fields: columnFields}
+	if err = w.begin(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Reopen an interrupted SQLite export, dropping any rows at or after the given time so they can be written again.
+func resumeSQLiteWriter(path string, fields []string, from time.Time) (*sqliteWriter, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s >= ?", sqliteTable, quoteIdentifier(timestampField)),
+		from.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	w := &sqliteWriter{path: path, db: db, fields: sqliteColumnFields(fields)}
+	if err = w.begin(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// The requested fields that get their own column. The timestamp column is always present.
+func sqliteColumnFields(fields []string) (columnFields []string) {
+	for _, f := range fields {
+		if f != timestampField {
+			columnFields = append(columnFields, f)
+		}
+	}
+	return columnFields
+}
+
+// Start a new transaction and prepare the insert statement in it.
+func (w *sqliteWriter) begin() (err error) {
+	w.tx, err = w.db.Begin()
+	if err != nil {
+		return err
+	}
+	names := []string{quoteIdentifier(timestampField)}
+	for _, f := range w.fields {
+		names = append(names, quoteIdentifier(f))
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	w.insert, err = w.tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		sqliteTable, strings.Join(names, ", "), placeholders))
+	w.pending = 0
+	return err
+}
+
+func (w *sqliteWriter) write(msg logMessage) error {
+	values := []interface{}{msg.timestamp.UTC().Format(sqliteTimeFormat)}
+	for _, f := range w.fields {
+		if value, ok := msg.fields[f]; ok {
+			values = append(values, value)
+		} else {
+			values = append(values, nil)
+		}
+	}
+	if _, err := w.insert.Exec(values...); err != nil {
+		return err
+	}
+
+	w.pending++
+	if w.pending >= sqliteBatchSize {
+		return w.commit()
+	}
+	return nil
+}
+
+// Commit the rows written so far.
+func (w *sqliteWriter) commit() error {
+	if err := w.tx.Commit(); err != nil {
+		return err
+	}
+	return w.begin()
+}
+
+// The size of the database file, rows still in the open transaction aren't counted.
+func (w *sqliteWriter) size() int64 {
+	if info, err := os.Stat(w.path); err == nil {
+		return info.Size()
+	}
+	return 0
+}
+
+func (w *sqliteWriter) Close() error {
+	err := w.tx.Commit()
+	if err2 := w.db.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Quote a table or column name for use in SQL.
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
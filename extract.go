@@ -0,0 +1,298 @@
+package main
+
+import (
+	"./config"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Payload types understood by the extraction rules.
+const jsonPayload = "json"
+const logfmtPayload = "logfmt"
+const autoPayload = "auto"
+
+// extractionRule parses a JSON document or key=value (logfmt) string held in a message field and adds its values to
+// the message under a prefix. Rules can be limited to messages of a stream or to a single format.
+type extractionRule struct {
+	name   string
+	field  string
+	kind   string
+	prefix string
+	stream string
+	format string
+}
+
+// Parse the extraction rules from the [extract] section of the configuration. Each rule is a list of key=value
+// settings, e.g., 'field=message type=json prefix=payload. stream=checkout format=format3'.
+func newExtractionRules(definitions []config.ExtractionDefinition) (rules []extractionRule, err error) {
+	for _, d := range definitions {
+		settings, ok := parseLogfmt(d.Rule)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected settings such as 'field=message type=json'", d.Name)
+		}
+		rule := extractionRule{name: d.Name, kind: autoPayload}
+		for k, v := range settings {
+			value, _ := v.(string)
+			switch k {
+			case "field":
+				rule.field = value
+			case "type":
+				rule.kind = strings.ToLower(value)
+			case "prefix":
+				rule.prefix = value
+			case "stream":
+				rule.stream = strings.ToLower(value)
+			case "format":
+				rule.format = value
+			default:
+				return nil, fmt.Errorf("%s: unknown setting '%s'", d.Name, k)
+			}
+		}
+		if len(rule.field) == 0 {
+			return nil, fmt.Errorf("%s: the 'field' setting is required", d.Name)
+		}
+		if rule.kind != jsonPayload && rule.kind != logfmtPayload && rule.kind != autoPayload {
+			return nil, fmt.Errorf("%s: the type must be json, logfmt or auto", d.Name)
+		}
+		if len(rule.prefix) == 0 {
+			rule.prefix = rule.field + "."
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Apply the extraction rules that aren't limited to a format.
+func applyExtractions(rules []extractionRule, msg logMessage, streamLookup map[string]map[string]string) {
+	for _, rule := range rules {
+		if len(rule.format) == 0 && rule.matchesStream(msg, streamLookup) {
+			rule.extract(msg)
+		}
+	}
+}
+
+// Create a copy of the message with the extraction rules for the format applied, so they only affect that format.
+// returns: false, and the message unchanged, when no rules are limited to the format.
+func applyFormatExtractions(rules []extractionRule, format string, msg logMessage,
+	streamLookup map[string]map[string]string) (result logMessage, copied bool) {
+	for _, rule := range rules {
+		if rule.format != format || !rule.matchesStream(msg, streamLookup) {
+			continue
+		}
+		if !copied {
+			msg = copyMessage(msg)
+			copied = true
+		}
+		rule.extract(msg)
+	}
+	return msg, copied
+}
+
+// Copy the fields and values of a message so they can be changed independently.
+func copyMessage(msg logMessage) logMessage {
+	fields := make(map[string]string, len(msg.fields))
+	for k, v := range msg.fields {
+		fields[k] = v
+	}
+	values := make(map[string]interface{}, len(msg.values))
+	for k, v := range msg.values {
+		values[k] = v
+	}
+	msg.fields = fields
+	msg.values = values
+	return msg
+}
+
+// Check whether the message belongs to the rule's stream. Stream titles match by prefix, like --stream.
+func (r *extractionRule) matchesStream(msg logMessage, streamLookup map[string]map[string]string) bool {
+	if len(r.stream) == 0 {
+		return true
+	}
+	for _, id := range msg.streams {
+		if strings.HasPrefix(strings.ToLower(streamLookup[id]["title"]), r.stream) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse the rule's field and add the values found to the message.
+func (r *extractionRule) extract(msg logMessage) {
+	text := msg.fields[r.field]
+	if len(text) == 0 {
+		return
+	}
+
+	var payload map[string]interface{}
+	var ok bool
+	if r.kind == jsonPayload || r.kind == autoPayload {
+		payload, ok = parseEmbeddedJSON(text)
+	}
+	if !ok && (r.kind == logfmtPayload || r.kind == autoPayload) {
+		payload, ok = parseLogfmt(text)
+	}
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSuffix(r.prefix, ".")
+	if name != r.prefix && name != r.field {
+		// Nested under a single name, so templates can use {{.payload.user}}
+		if msg.values != nil {
+			msg.values[name] = payload
+		}
+		flattenValue(msg.fields, name, payload)
+	} else {
+		for k, v := range payload {
+			if msg.values != nil {
+				msg.values[r.prefix+k] = v
+			}
+			flattenValue(msg.fields, r.prefix+k, v)
+		}
+	}
+}
+
+// Parse a JSON object embedded in text. Anything before the first '{' (a log prefix, for example) is skipped.
+func parseEmbeddedJSON(text string) (map[string]interface{}, bool) {
+	start := strings.Index(text, "{")
+	if start < 0 {
+		return nil, false
+	}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(text[start:])))
+	decoder.UseNumber()
+	var payload map[string]interface{}
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, false
+	}
+	return payload, true
+}
+
+// Parse key=value pairs (logfmt). Values may be double quoted, with backslash escapes. Keys without a value are
+// treated as true.
+// returns: false if no key=value pair was found.
+func parseLogfmt(text string) (map[string]interface{}, bool) {
+	payload := make(map[string]interface{})
+	var pairs int
+	i := 0
+	for i < len(text) {
+		for i < len(text) && text[i] == ' ' {
+			i++
+		}
+		start := i
+		for i < len(text) && text[i] != '=' && text[i] != ' ' {
+			i++
+		}
+		key := text[start:i]
+		if len(key) == 0 {
+			i++
+			continue
+		}
+		if i >= len(text) || text[i] != '=' {
+			payload[key] = true
+			continue
+		}
+		i++
+
+		var value strings.Builder
+		if i < len(text) && text[i] == '"' {
+			i++
+			for i < len(text) && text[i] != '"' {
+				if text[i] == '\\' && i+1 < len(text) {
+					i++
+					switch text[i] {
+					case 'n':
+						value.WriteByte('\n')
+					case 't':
+						value.WriteByte('\t')
+					default:
+						value.WriteByte(text[i])
+					}
+				} else {
+					value.WriteByte(text[i])
+				}
+				i++
+			}
+			i++
+		} else {
+			for i < len(text) && text[i] != ' ' {
+				value.WriteByte(text[i])
+				i++
+			}
+		}
+		payload[key] = value.String()
+		pairs++
+	}
+	return payload, pairs > 0
+}
+
+// Regex extractors: '<source field> =~ <regex>'.
+var regexExtractorRegex = regexp.MustCompile(`^(\S+)\s+=~\s+(.+)$`)
+
+// fieldExtractor computes a derived field, either from the capture groups of a regex applied to a source field, or
+// from a template over the other fields.
+type fieldExtractor struct {
+	field    string
+	source   string
+	regex    *regexp.Regexp
+	template string
+}
+
+// Parse the derived fields from the [extractors] section of the configuration. A definition of the form
+// '<source field> =~ <regex>' sets the field to the first capture group (or the whole match), and named groups set
+// fields of the same name. Anything else is a template, e.g., '_user: {{.user_name}}@{{.source}}'.
+func newFieldExtractors(definitions []config.ExtractorDefinition) (extractors []fieldExtractor, err error) {
+	for _, d := range definitions {
+		extractor := fieldExtractor{field: d.Field}
+		if m := regexExtractorRegex.FindStringSubmatch(d.Rule); m != nil {
+			extractor.source = m[1]
+			extractor.regex, err = regexp.Compile(m[2])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", d.Field, err.Error())
+			}
+		} else {
+			_, err = template.New(d.Field).Funcs(templateFuncs).Parse(d.Rule)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", d.Field, err.Error())
+			}
+			extractor.template = d.Rule
+		}
+		extractors = append(extractors, extractor)
+	}
+	return extractors, nil
+}
+
+// Compute the derived fields, in order, so later definitions can use the fields of earlier ones. Fields whose source
+// is missing, whose regex doesn't match, or whose template refers to a missing field are left unset.
+func applyExtractors(extractors []fieldExtractor, msg logMessage) {
+	for _, e := range extractors {
+		if e.regex == nil {
+			if text := tryFormat(templateData(msg), e.field, e.template); len(text) > 0 {
+				msg.fields[e.field] = text
+			}
+			continue
+		}
+
+		source, ok := msg.fields[e.source]
+		if !ok {
+			continue
+		}
+		m := e.regex.FindStringSubmatch(source)
+		if m == nil {
+			continue
+		}
+		if len(m) > 1 {
+			msg.fields[e.field] = m[1]
+		} else {
+			msg.fields[e.field] = m[0]
+		}
+		for i, name := range e.regex.SubexpNames() {
+			if len(name) > 0 && len(m[i]) > 0 {
+				msg.fields[name] = m[i]
+			}
+		}
+	}
+}
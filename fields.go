@@ -1,6 +1,8 @@
 package main
 
 const classnameField = "classname"
+const dateField = "_date"
+const epochMillisField = "_epoch_ms"
 const fullMessageField = "full_message"
 const levelColorField = "_level_color"
 const levelField = "level"
@@ -10,9 +12,12 @@ const matchingStreamsField = "_matching_streams"
 const messageField = "message"
 const messageTextField = "_message_text"
 const originalMessageField = "original_message"
+const relativeTimeField = "_relative_time"
 const requestPageField = "request_page"
 const resetField = "_reset"
 const shortClassnameField = "_short_classname"
+const sourceField = "source"
+const timeField = "_time"
 const timestampField = "timestamp"
 
 
@@ -1,9 +1,25 @@
 package main
 
 import (
+	"./config"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
 )
 
 func ExampleExpand() {
@@ -13,6 +29,42 @@ func ExampleExpand() {
 	// then line2
 }
 
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{`line1\nthen line2`, "line1\nthen line2"},
+		{`tab\there\r\n`, "tab\there\r\n"},
+		{`say \"hi\"`, `say "hi"`},
+		{`C:\\temp`, `C:\temp`},
+		{`literal \\n stays`, `literal \n stays`},
+		{`caf\u00e9`, "caf\u00e9"},
+		{`slash \/ path`, "slash / path"},
+		{`no escapes`, "no escapes"},
+		{`broken \x escape`, `broken \x escape`},
+	}
+	for _, tt := range tests {
+		if got := Expand(tt.raw); got != tt.want {
+			t.Errorf("Expand(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestGetJSONSimpleMap(t *testing.T) {
+	data := []byte(`{"title":"Caf\u00e9 \"prod\"","path":"C:\\new","count":3,"enabled":true,"rules":[]}`)
+	got := getJSONSimpleMap(data)
+	want := map[string]string{"title": `Café "prod"`, "path": `C:\new`, "count": "3", "enabled": "true"}
+	if len(got) != len(want) {
+		t.Errorf("getJSONSimpleMap() = %v", got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("getJSONSimpleMap()[%q] = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
 func TestExpandPath(t *testing.T) {
 	path1 := expandPath("~/.graylog")
 
@@ -23,3 +75,1172 @@ func TestExpandPath(t *testing.T) {
 		t.Errorf("expandPath(\"~/.graylog\") = %s", path1)
 	}
 }
+
+func TestMatchesColorPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"5xx", "503", true},
+		{"5xx", "404", false},
+		{"400-499", "404", true},
+		{"400-499", "500", false},
+		{"ERROR", "error", true},
+		{"2xx", "20", false},
+	}
+	for _, tt := range tests {
+		if got := matchesColorPattern(tt.pattern, tt.value); got != tt.want {
+			t.Errorf("matchesColorPattern(%q, %q) = %v", tt.pattern, tt.value, got)
+		}
+	}
+}
+
+func TestDecideColors(t *testing.T) {
+	tests := []struct {
+		mode     string
+		noColor  string
+		force    string
+		terminal bool
+		want     bool
+	}{
+		{colorAuto, "", "", true, true},
+		{colorAuto, "", "", false, false},
+		{colorAuto, "1", "", true, false},
+		{colorAuto, "1", "1", false, false},
+		{colorAuto, "", "1", false, true},
+		{colorAuto, "", "0", false, false},
+		{colorAlways, "", "", false, true},
+		{colorAlways, "1", "", false, true},
+		{colorNever, "", "", true, false},
+		{colorNever, "", "1", true, false},
+	}
+	for _, tt := range tests {
+		env := map[string]string{"NO_COLOR": tt.noColor, "CLICOLOR_FORCE": tt.force}
+		getenv := func(key string) string { return env[key] }
+		if got := decideColors(tt.mode, getenv, tt.terminal); got != tt.want {
+			t.Errorf("decideColors(%q, NO_COLOR=%q, CLICOLOR_FORCE=%q, terminal=%v) = %v",
+				tt.mode, tt.noColor, tt.force, tt.terminal, got)
+		}
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	f, err := ioutil.TempFile("", "graylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if isTerminal(f) {
+		t.Errorf("isTerminal(%s) = true for a regular file", f.Name())
+	}
+}
+
+func TestScreenLines(t *testing.T) {
+	text := "short\n" + infoEsc + "0123456789" + resetEsc + "\n0123456789ABCDEFGHIJ!\n"
+	if got := screenLines(text, 10); got != 5 {
+		t.Errorf("screenLines() = %d, want 5", got)
+	}
+}
+
+func TestMissingPager(t *testing.T) {
+	defer os.Setenv("PAGER", os.Getenv("PAGER"))
+	os.Setenv("PAGER", "graylog-missing-pager")
+	output := bytes.NewBufferString("line 1\nline 2\n")
+	if err := runPager(output); err == nil {
+		t.Error("runPager() didn't report the missing pager")
+	}
+	if output.String() != "line 1\nline 2\n" {
+		t.Errorf("runPager() lost the output, left %q", output.String())
+	}
+}
+
+func TestLongTime(t *testing.T) {
+	ts := time.Date(2019, 6, 19, 14, 30, 15, 123000000, time.UTC)
+	if got := longTime(ts, time.UTC, config.DefaultTimeFormat); got != "2019-06-19T14:30:15.123Z" {
+		t.Errorf("longTime(UTC) = %s", got)
+	}
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("timezone database not available")
+	}
+	if got := longTime(ts, ny, config.DefaultTimeFormat); got != "2019-06-19T10:30:15.123-04:00" {
+		t.Errorf("longTime(America/New_York) = %s", got)
+	}
+}
+
+func TestFoldStacktraces(t *testing.T) {
+	trace := strings.Join([]string{
+		"java.lang.IllegalStateException: boom",
+		"\tat org.springframework.web.Dispatcher.run(Dispatcher.java:10)",
+		"\tat com.example.app.Service.call(Service.java:42)",
+		"\tat com.example.app.Controller.get(Controller.java:7)",
+		"\tat org.apache.catalina.Valve.invoke(Valve.java:99)",
+		"Caused by: java.io.IOException: closed",
+		"\tat com.example.app.Client.read(Client.java:3)",
+		"\t... 12 more",
+	}, "\n")
+	want := strings.Join([]string{
+		"java.lang.IllegalStateException: boom",
+		"\tat com.example.app.Service.call(Service.java:42)",
+		"\t... 3 frames folded",
+		"Caused by: java.io.IOException: closed",
+		"\tat com.example.app.Client.read(Client.java:3)",
+		"\t... 1 frames folded",
+	}, "\n")
+	folding := &traceFolding{frames: 1, packages: []string{"com.example"}}
+	if got := foldStacktraces(trace, folding); got != want {
+		t.Errorf("foldStacktraces() =\n%s\nwant:\n%s", got, want)
+	}
+	if got := foldStacktraces(trace, nil); got != trace {
+		t.Errorf("foldStacktraces(nil) changed the trace:\n%s", got)
+	}
+}
+
+func TestExportFormatFromPath(t *testing.T) {
+	tests := map[string]string{
+		"export.csv":        csvFormat,
+		"export.csv.gz":     csvFormat,
+		"-":                 csvFormat,
+		"logs.jsonl":        ndjsonFormat,
+		"logs.NDJSON.gz":    ndjsonFormat,
+		"incident.sqlite":   sqliteFormat,
+		"incident.db":       sqliteFormat,
+		"day.parquet":       parquetFormat,
+		"no-extension-file": csvFormat,
+	}
+	for path, want := range tests {
+		if got := exportFormatFromPath(path); got != want {
+			t.Errorf("exportFormatFromPath(%q) = %s, want %s", path, got, want)
+		}
+	}
+}
+
+func TestCheckExportCompression(t *testing.T) {
+	for path, format := range map[string]string{"day.parquet.gz": parquetFormat, "incident.db.gz": sqliteFormat} {
+		if err := checkExportCompression(format, path); err == nil {
+			t.Errorf("checkExportCompression(%s, %q) accepted a compressed export", format, path)
+		}
+	}
+	for path, format := range map[string]string{"logs.ndjson.gz": ndjsonFormat, "export.csv.gz": csvFormat,
+		"day.parquet": parquetFormat} {
+		if err := checkExportCompression(format, path); err != nil {
+			t.Errorf("checkExportCompression(%s, %q) = %v", format, path, err)
+		}
+	}
+}
+
+func TestExportWriters(t *testing.T) {
+	dir := t.TempDir()
+	fields := []string{timestampField, "source", "took_ms"}
+	messages := []logMessage{
+		{timestamp: time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC), fields: map[string]string{"source": "web1", "took_ms": "250"}},
+		{timestamp: time.Date(2019, 6, 1, 12, 0, 1, 500000000, time.UTC), fields: map[string]string{"source": "web2"}},
+	}
+	for i := range messages {
+		messages[i].fields[timestampField] = messages[i].timestamp.Format(graylogOutputTimeFormat)
+	}
+
+	ndjsonPath := filepath.Join(dir, "export.ndjson")
+	w, err := newNDJSONWriter(ndjsonPath, false, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range messages {
+		if err := w.write(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(ndjsonPath)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("NDJSON export = %q", data)
+	}
+	if w.size() != int64(len(data)) {
+		t.Errorf("NDJSON size() = %d, want %d", w.size(), len(data))
+	}
+
+	// Compressed exports report the bytes written, not the size of the file
+	gz, err := newNDJSONWriter(ndjsonPath+".gz", false, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range messages {
+		_ = gz.write(msg)
+	}
+	_ = gz.Close()
+	if gz.size() != int64(len(data)) {
+		t.Errorf("compressed NDJSON size() = %d, want %d", gz.size(), len(data))
+	}
+	var row map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &row); err != nil || row["source"] != "web2" || row["took_ms"] != nil ||
+		row[timestampField] != "2019-06-01T12:00:01.500Z" {
+		t.Errorf("NDJSON row = %v, %v", row, err)
+	}
+
+	sqlitePath := filepath.Join(dir, "export.db")
+	w, err = newSQLiteWriter(sqlitePath, false, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range messages {
+		if err := w.write(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(sqlitePath); info == nil || w.size() != info.Size() {
+		t.Errorf("SQLite size() = %d", w.size())
+	}
+	db, err := sql.Open("sqlite3", sqlitePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT timestamp, source, took_ms FROM messages ORDER BY timestamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var ts, source string
+		var took sql.NullString
+		if err := rows.Scan(&ts, &source, &took); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s %s %v", ts, source, took.String))
+	}
+	if strings.Join(got, "; ") != "2019-06-01T12:00:00.000Z web1 250; 2019-06-01T12:00:01.500Z web2 " {
+		t.Errorf("SQLite rows = %v", got)
+	}
+}
+
+func TestPageMessages(t *testing.T) {
+	// Three messages per millisecond, so pages end in the middle of a timestamp. Like Elasticsearch's result window,
+	// the server refuses to page far.
+	start := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	var all []string
+	for i := 0; i < 2500; i++ {
+		ts := start.Add(time.Duration(i/3) * time.Millisecond).Format(graylogOutputTimeFormat)
+		all = append(all, fmt.Sprintf(`{"message":{"_id":"m%d","timestamp":"%s"}}`, i, ts))
+	}
+	failing := false
+	opts := newTestConfig(t, "", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/streams" {
+			fmt.Fprint(w, `{"streams":[]}`)
+			return
+		}
+		q := r.URL.Query()
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		if failing || offset+limit > 1500 {
+			http.Error(w, `{"message":"Result window is too large"}`, http.StatusInternalServerError)
+			return
+		}
+		from, _ := time.Parse(graylogInputTimeFormat, q.Get("from"))
+		var matching []string
+		for i, m := range all {
+			if !start.Add(time.Duration(i/3) * time.Millisecond).Before(from) {
+				matching = append(matching, m)
+			}
+		}
+		if offset > len(matching) {
+			offset = len(matching)
+		}
+		if offset+limit > len(matching) {
+			limit = len(matching) - offset
+		}
+		fmt.Fprintf(w, `{"messages":[%s]}`, strings.Join(matching[offset:offset+limit], ","))
+	})
+	end := start.Add(time.Hour)
+	opts.startDate, opts.endDate, opts.fields, opts.location = &start, &end, "source", time.UTC
+	opts.colors, _ = newColorScheme(nil)
+
+	var ids []string
+	err := pageMessages(opts, func(messages []logMessage) error {
+		for _, msg := range messages {
+			ids = append(ids, msg.id)
+		}
+		return nil
+	})
+	if err != nil || len(ids) != len(all) || ids[999] != "m999" || ids[1000] != "m1000" || ids[2499] != "m2499" {
+		t.Errorf("pageMessages() = %d messages, %v", len(ids), err)
+	}
+
+	failing = true
+	if err := pageMessages(opts, func([]logMessage) error { return nil }); err == nil {
+		t.Error("pageMessages() didn't report the failed search")
+	}
+}
+
+func TestSplitTimeRange(t *testing.T) {
+	start := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	chunks := splitTimeRange(start, start.Add(150*time.Minute), time.Hour)
+	if len(chunks) != 3 {
+		t.Fatalf("splitTimeRange() returned %d chunks, want 3", len(chunks))
+	}
+	if !chunks[1].from.Equal(start.Add(time.Hour)) || !chunks[2].to.Equal(start.Add(150*time.Minute)) {
+		t.Errorf("splitTimeRange() = %v", chunks)
+	}
+}
+
+func TestCreateExportWriter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "export.csv")
+	if err := ioutil.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createExportWriter(path, false); err == nil {
+		t.Error("createExportWriter() replaced an existing file without --force")
+	}
+	if err := checkOverwrite(path, false); err == nil {
+		t.Error("checkOverwrite() accepted an existing file without --force")
+	}
+	w, err := createExportWriter(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte("new"))
+	_ = w.Close()
+	if written, _ := ioutil.ReadFile(path); string(written) != "new" {
+		t.Errorf("createExportWriter(force) left %q", written)
+	}
+	if err := checkOverwrite(path, true); err != nil {
+		t.Errorf("checkOverwrite(force) = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("checkOverwrite(force) didn't remove the file")
+	}
+
+	gzPath := filepath.Join(dir, "export.csv.gz")
+	w, err = createExportWriter(gzPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := &countingWriter{w: w}
+	_, _ = counter.Write([]byte("\"a\",\"line 1\nline 2\"\n\"b\",\"x\"\n"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if counter.bytes != 28 || counter.records != 2 {
+		t.Errorf("countingWriter counted %d bytes and %d records", counter.bytes, counter.records)
+	}
+	f, _ := os.Open(gzPath)
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(zr); string(data) != "\"a\",\"line 1\nline 2\"\n\"b\",\"x\"\n" {
+		t.Errorf("gzip export = %q", data)
+	}
+
+	r, pw, _ := os.Pipe()
+	stdout := os.Stdout
+	os.Stdout = pw
+	w, err = createExportWriter(stdoutPath, false)
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte("to stdout"))
+	_ = w.Close()
+	_ = pw.Close()
+	if data, _ := ioutil.ReadAll(r); string(data) != "to stdout" {
+		t.Errorf("'-' export wrote %q to stdout", data)
+	}
+}
+
+func TestCopyCSVChunk(t *testing.T) {
+	chunk := "\"source\",\"message\"\n\"a\",\"line 1\nline 2\"\n\"b\",\"x\"\n"
+
+	var first bytes.Buffer
+	rows, err := copyCSVChunk(&first, strings.NewReader(chunk), true)
+	if err != nil || rows != 2 || first.String() != chunk {
+		t.Errorf("copyCSVChunk(keepHeader) = %d, %v, %q", rows, err, first.String())
+	}
+
+	var next bytes.Buffer
+	rows, err = copyCSVChunk(&next, strings.NewReader(chunk), false)
+	if err != nil || rows != 2 || next.String() != "\"a\",\"line 1\nline 2\"\n\"b\",\"x\"\n" {
+		t.Errorf("copyCSVChunk() = %d, %v, %q", rows, err, next.String())
+	}
+}
+
+func TestResumeFirstChunk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.ndjson")
+	if err := ioutil.WriteFile(path, []byte(`{"source":"partial`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Interrupted during the first chunk: the checkpoint has no completed chunk, and the export isn't forced
+	opts := &options{exportPath: path, exportFormat: ndjsonFormat, fields: "source", resume: true}
+	sink, err := newChunkSink(opts, &exportCheckpoint{}, time.Now())
+	if err != nil {
+		t.Fatalf("newChunkSink() = %v", err)
+	}
+	msg := logMessage{fields: map[string]string{"source": "web1"}}
+	if _, err := sink.writeChunk(chunkResult{messages: []logMessage{msg}}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if written, _ := ioutil.ReadFile(path); string(written) != `{"source":"web1"}`+"\n" {
+		t.Errorf("resumed export = %q", written)
+	}
+}
+
+func TestResumeFailedChunk(t *testing.T) {
+	// One message in the middle of each hour, the search of the second hour fails until fixed
+	start := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	failing := true
+	opts := newTestConfig(t, "", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/streams" {
+			fmt.Fprint(w, `{"streams":[]}`)
+			return
+		}
+		from, _ := time.Parse(graylogInputTimeFormat, r.URL.Query().Get("from"))
+		if failing && from.Equal(start.Add(time.Hour)) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		hour := from.Truncate(time.Hour)
+		if r.URL.Query().Get("offset") != "0" || from.After(hour.Add(30*time.Minute)) {
+			fmt.Fprint(w, `{"messages":[]}`)
+			return
+		}
+		fmt.Fprintf(w, `{"messages":[{"message":{"_id":"m%d","source":"web%d","timestamp":"%s"}}]}`, hour.Hour(),
+			hour.Hour(), hour.Add(30*time.Minute).Format(graylogOutputTimeFormat))
+	})
+	end := start.Add(3 * time.Hour)
+	opts.exportPath = filepath.Join(t.TempDir(), "export.ndjson")
+	opts.exportFormat, opts.fields, opts.location = ndjsonFormat, "source", time.UTC
+	opts.colors, _ = newColorScheme(nil)
+	checkpointPath := opts.exportPath + checkpointSuffix
+	checkpoint := exportCheckpoint{Start: start, End: end, ChunkSecs: 3600}
+	chunks := splitTimeRange(start, end, time.Hour)
+
+	sink, err := newChunkSink(opts, &checkpoint, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeChunks(opts, sink, &checkpoint, checkpointPath, chunks); err == nil {
+		t.Error("writeChunks() didn't report the failed chunk")
+	}
+	_ = sink.Close()
+	saved, err := readCheckpoint(checkpointPath)
+	if err != nil || saved.Completed != 1 {
+		t.Fatalf("checkpoint after the failed chunk = %+v, %v", saved, err)
+	}
+
+	failing = false
+	opts.resume = true
+	sink, err = newChunkSink(opts, saved, chunks[saved.Completed].from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeChunks(opts, sink, saved, checkpointPath, chunks); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	written, _ := ioutil.ReadFile(opts.exportPath)
+	if string(written) != `{"source":"web0"}`+"\n"+`{"source":"web1"}`+"\n"+`{"source":"web2"}`+"\n" {
+		t.Errorf("resumed export = %q", written)
+	}
+}
+
+func TestRotatedName(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := map[string]string{
+		"export.csv":           "export-20190601T120000.csv",
+		"/var/log/tail.ndjson": "/var/log/tail-20190601T120000.ndjson",
+		"records.csv.gz":       "records-20190601T120000.csv.gz",
+	}
+	for path, want := range tests {
+		if got := rotatedName(path, now); got != want {
+			t.Errorf("rotatedName(%q) = %s, want %s", path, got, want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{"0": 0, "512": 512, "500KB": 500 << 10, "100MB": 100 << 20, "1.5G": 3 << 29}
+	for size, want := range tests {
+		if got, err := parseSize(size); err != nil || got != want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", size, got, err, want)
+		}
+	}
+	if _, err := parseSize("12XB"); err == nil {
+		t.Errorf("parseSize(\"12XB\") didn't fail")
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ts := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	messages := []logMessage{
+		{id: "2", timestamp: ts.Add(time.Second), streams: []string{"s1"}, fields: map[string]string{"message": "second"}},
+		{id: "1", timestamp: ts, streams: []string{"s1"}, fields: map[string]string{"message": "first"}},
+	}
+	streams := map[string]map[string]string{"s1": {"id": "s1", "title": "Production"}}
+
+	for _, name := range []string{"incident.ndjson", "incident.ndjson.gz"} {
+		path := filepath.Join(dir, name)
+		// Saving twice must not duplicate the messages
+		for i := 0; i < 2; i++ {
+			if err := saveArchive(path, messages, streams); err != nil {
+				t.Fatal(err)
+			}
+		}
+		loaded, loadedStreams, err := loadArchive(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(loaded) != 2 || loaded[0].id != "1" || loaded[1].fields["message"] != "second" {
+			t.Errorf("loadArchive(%s) = %v", name, loaded)
+		}
+		if loadedStreams["s1"]["title"] != "Production" {
+			t.Errorf("loadArchive(%s) streams = %v", name, loadedStreams)
+		}
+
+		// Searches get their own copies, changing them leaves the archive alone
+		archiveMessages, archiveStreams = loaded, loadedStreams
+		found := fetchArchivedMessages(&options{archivePath: path})
+		found[0].fields["message"] = "changed"
+		found[0].values["payload.user"] = "alice"
+		if archiveMessages[0].fields["message"] != "first" || archiveMessages[0].values["payload.user"] != nil {
+			t.Errorf("fetchArchivedMessages() shares the archived message %+v", archiveMessages[0])
+		}
+		archiveMessages, archiveStreams = nil, nil
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	command, commandArgs, rest := splitCommand([]string{"graylog", "save", "out.ndjson", "-r", "1h"})
+	if command != saveCommand || len(commandArgs) != 1 || commandArgs[0] != "out.ndjson" || len(rest) != 3 {
+		t.Errorf("splitCommand(save) = %q, %v, %v", command, commandArgs, rest)
+	}
+	command, _, rest = splitCommand([]string{"graylog", "-q", "save"})
+	if command != "" || len(rest) != 3 {
+		t.Errorf("splitCommand(search) = %q, %v", command, rest)
+	}
+	command, commandArgs, rest = splitCommand([]string{"graylog", "run", "errors", "web1", "500", "-t"})
+	if command != runCommand || len(commandArgs) != 3 || commandArgs[2] != "500" || len(rest) != 2 {
+		t.Errorf("splitCommand(run) = %q, %v, %v", command, commandArgs, rest)
+	}
+	command, commandArgs, _ = splitCommand([]string{"graylog", "searches", "save", "errors", "-q", "x"})
+	if command != searchesSaveCommand || len(commandArgs) != 1 || len(requiredArguments(runCommand)) != 1 {
+		t.Errorf("splitCommand(searches save) = %q, %v", command, commandArgs)
+	}
+}
+
+// Create the options of a test, with a config file made of a [server] section followed by the ini text. The server
+// is a test server running the handler, or localhost when there's no handler.
+func newTestConfig(t *testing.T, ini string, handler http.HandlerFunc) *options {
+	t.Helper()
+	uri := "http://localhost"
+	if handler != nil {
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+		uri = server.URL
+	}
+	path := filepath.Join(t.TempDir(), "graylog.ini")
+	if err := ioutil.WriteFile(path, []byte("[server]\nuri: "+uri+"/api\n\n"+ini), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &options{serverConfig: cfg, configPath: path}
+}
+
+func TestSavedSearches(t *testing.T) {
+	opts := newTestConfig(t, "", nil)
+	err := opts.serverConfig.SaveSearch(config.SavedSearch{Name: "errors", Query: "source:{{.arg1}} AND level:3",
+		Range: "{{.arg2}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, _ := config.New(opts.configPath)
+	saved, ok := cfg.Search("errors")
+	if !ok || cfg.Uri() != "http://localhost/api" {
+		t.Fatalf("Search(errors) = %v, %v", saved, ok)
+	}
+	search, err := expandSearch(saved, []string{"web1", "4h"})
+	if err != nil || search.Query != "source:web1 AND level:3" || search.Range != "4h" {
+		t.Errorf("expandSearch() = %v, %v", search, err)
+	}
+	if _, err := expandSearch(saved, []string{"web1"}); err == nil {
+		t.Error("expandSearch() accepted a missing argument")
+	}
+}
+
+func TestNestedValuesInTemplates(t *testing.T) {
+	response := []byte(`{"messages":[{"message":{"_id":"1","timestamp":"2019-06-01T12:00:00.000Z",` +
+		`"source":"web1","http":{"status":503,"method":"GET"},"tags":["a","b"],"retry":true,"streams":["s1"]}}]}`)
+	messages := parseMessages(response)
+	if len(messages) != 1 {
+		t.Fatalf("parseMessages() returned %d messages", len(messages))
+	}
+	msg := messages[0]
+
+	if msg.fields["http.status"] != "503" || msg.fields["retry"] != "true" || msg.fields["tags"] != `["a","b"]` {
+		t.Errorf("flattened fields = %v", msg.fields)
+	}
+	if len(msg.streams) != 1 || msg.streams[0] != "s1" {
+		t.Errorf("streams = %v", msg.streams)
+	}
+
+	text := tryFormat(templateData(msg), "test", "{{.source}} {{.http.status}} {{index .tags 1}} {{.retry}}")
+	if text != "web1 503 b true" {
+		t.Errorf("tryFormat() = %q", text)
+	}
+
+	// Fields rewritten while adjusting keep the value that's displayed
+	msg.values["level"] = json.Number("3")
+	msg.fields["level"] = "ERROR"
+	msg.fields["retry"] = "yes"
+	data := templateData(msg)
+	if data["level"] != "ERROR" || data["retry"] != "yes" || data["http"] == nil {
+		t.Errorf("templateData() = %v", data)
+	}
+}
+
+func TestExtractions(t *testing.T) {
+	rules, err := newExtractionRules([]config.ExtractionDefinition{
+		{Name: "payload", Rule: "field=message type=json prefix=payload."},
+		{Name: "kv", Rule: `field=full_message type=logfmt prefix=kv_`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := logMessage{
+		fields: map[string]string{
+			"message":      `request done {"user":"ann","http":{"status":503}}`,
+			"full_message": `level=warn msg="slow \"query\"" took=1.5s cached`,
+		},
+		values: map[string]interface{}{},
+	}
+	applyExtractions(rules, msg, nil)
+
+	if msg.fields["payload.user"] != "ann" || msg.fields["payload.http.status"] != "503" {
+		t.Errorf("json fields = %v", msg.fields)
+	}
+	if msg.fields["kv_msg"] != `slow "query"` || msg.fields["kv_took"] != "1.5s" || msg.fields["kv_cached"] != "true" {
+		t.Errorf("logfmt fields = %v", msg.fields)
+	}
+	if text := tryFormat(templateData(msg), "test", "{{.payload.http.status}} {{.kv_level}}"); text != "503 warn" {
+		t.Errorf("tryFormat() = %q", text)
+	}
+
+	if _, ok := parseLogfmt("no pairs here"); ok {
+		t.Error("parseLogfmt() accepted plain text")
+	}
+	if _, err := newExtractionRules([]config.ExtractionDefinition{{Name: "bad", Rule: "type=json"}}); err == nil {
+		t.Error("newExtractionRules() accepted a rule without a field")
+	}
+}
+
+func TestFieldExtractors(t *testing.T) {
+	extractors, err := newFieldExtractors([]config.ExtractorDefinition{
+		{Field: "_trace_id", Rule: `message =~ trace=([0-9a-f]+)`},
+		{Field: "_request", Rule: `request_page =~ ^/(?P<_area>\w+)/`},
+		{Field: "_user", Rule: "{{.user_name}}@{{.source}}"},
+		{Field: "_where", Rule: "{{._area}} {{._trace_id}}"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := logMessage{fields: map[string]string{
+		"message":      "done trace=4bf92f35 in 12ms",
+		"request_page": "/orders/42",
+		"source":       "web1",
+	}}
+	applyExtractors(extractors, msg)
+
+	if msg.fields["_trace_id"] != "4bf92f35" || msg.fields["_request"] != "orders" || msg.fields["_area"] != "orders" {
+		t.Errorf("regex fields = %v", msg.fields)
+	}
+	if _, ok := msg.fields["_user"]; ok {
+		t.Errorf("_user = %q, expected it to be unset without user_name", msg.fields["_user"])
+	}
+	if msg.fields["_where"] != "orders 4bf92f35" {
+		t.Errorf("_where = %q", msg.fields["_where"])
+	}
+
+	if _, err := newFieldExtractors([]config.ExtractorDefinition{{Field: "bad", Rule: "message =~ ("}}); err == nil {
+		t.Error("newFieldExtractors() accepted an invalid regex")
+	}
+}
+
+func TestWhere(t *testing.T) {
+	fields := map[string]string{
+		"loglevel":      "ERROR",
+		"http.status":   "503",
+		"bytes":         "900",
+		"limit":         "1000",
+		"_message_text": "Request timeout\n\tat com.example.Foo.bar(Foo.java:42)",
+		"retry":         "false",
+	}
+	tests := []struct {
+		expr     string
+		expected bool
+	}{
+		{`loglevel == "ERROR"`, true},
+		{`loglevel != 'ERROR'`, false},
+		{`http.status >= 500 and http.status < 600`, true},
+		{`bytes < limit`, true},
+		{`_message_text =~ "com\\.example" && !(loglevel == "INFO")`, true},
+		{`_message_text !~ "^timeout"`, true},
+		{`exists(http.status) or missing == 1`, true},
+		{`not exists user`, true},
+		{`missing != "x"`, true},
+		{`missing < 5`, false},
+		{`retry`, false},
+		{`loglevel == "WARN" or (loglevel == "ERROR" and bytes > 1000)`, false},
+	}
+	for _, test := range tests {
+		expr, err := parseWhere(test.expr)
+		if err != nil {
+			t.Errorf("parseWhere(%q) failed: %s", test.expr, err.Error())
+			continue
+		}
+		if actual := expr.eval(fields); actual != test.expected {
+			t.Errorf("%q = %v, expected %v", test.expr, actual, test.expected)
+		}
+	}
+
+	for _, invalid := range []string{`loglevel ==`, `(a == 1`, `message =~ pattern`, `"x"`, `a == "b`, `a = 1`} {
+		if _, err := parseWhere(invalid); err == nil {
+			t.Errorf("parseWhere(%q) accepted an invalid expression", invalid)
+		}
+	}
+}
+
+func TestBuildQuery(t *testing.T) {
+	query, err := buildQuery(queryTerms{
+		query:   "timeout OR refused",
+		fields:  []string{"http_method=POST", "path=/api/*", "http_method=PUT"},
+		not:     []string{"source=health check"},
+		sources: []string{"web1", "web2"},
+		exists:  []string{"trace_id"},
+	})
+	expected := `(timeout OR refused) AND (source:"web1" OR source:"web2") AND ` +
+		`(http_method:"POST" OR http_method:"PUT") AND path:\/api\/* AND _exists_:trace_id AND NOT source:"health check"`
+	if err != nil || query != expected {
+		t.Errorf("buildQuery() = %q, %v\nexpected %q", query, err, expected)
+	}
+
+	query, _ = buildQuery(queryTerms{not: []string{`message=say "hi"`}})
+	if query != `* AND NOT message:"say \"hi\""` {
+		t.Errorf("buildQuery(not) = %q", query)
+	}
+
+	if _, err := buildQuery(queryTerms{fields: []string{"novalue"}}); err == nil {
+		t.Error("buildQuery() accepted a --field without a value")
+	}
+}
+
+func TestLevelQuery(t *testing.T) {
+	query, err := levelQuery(">=ERROR")
+	expected := "(loglevel:(ERROR OR error OR CRITICAL OR critical OR FATAL OR fatal OR ALERT OR alert OR " +
+		"EMERGENCY OR emergency) OR level:(0 OR 1 OR 2 OR 3))"
+	if err != nil || query != expected {
+		t.Errorf("levelQuery(>=ERROR) = %q, %v", query, err)
+	}
+	query, _ = levelQuery("warning")
+	if query != "(loglevel:(WARN OR warn OR WARNING OR warning) OR level:(4))" {
+		t.Errorf("levelQuery(warning) = %q", query)
+	}
+	for _, invalid := range []string{">=LOUD", "~WARN", ">EMERGENCY"} {
+		if _, err := levelQuery(invalid); err == nil {
+			t.Errorf("levelQuery(%q) accepted an invalid level", invalid)
+		}
+	}
+}
+
+func TestFetchServerSearches(t *testing.T) {
+	responses := map[string]string{
+		"/api/views": `{"views":[{"type":"SEARCH","title":"Checkout errors","search_id":"s1"},` +
+			`{"type":"DASHBOARD","title":"Overview","search_id":"s2"}]}`,
+		"/api/views/search/s1": `{"queries":[{"query":{"type":"elasticsearch","query_string":"level:3"},` +
+			`"timerange":{"type":"relative","range":3600},` +
+			`"filter":{"type":"or","filters":[{"type":"stream","id":"st1"},{"type":"stream","id":"st2"}]}}]}`,
+		"/api/search/saved": `{"total":1,"searches":[{"title":"Old","query":{"query":"source:web1",` +
+			`"rangeType":"absolute","from":"2019-06-01T00:00:00.000Z","to":"2019-06-02T00:00:00.000Z","streamId":"st3"}}]}`,
+	}
+	views := true
+	opts := newTestConfig(t, "", func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok || (!views && strings.HasPrefix(r.URL.Path, "/api/views")) {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	})
+
+	searches, err := fetchServerSearches(opts)
+	if err != nil || len(searches) != 1 {
+		t.Fatalf("fetchServerSearches() = %v, %v", searches, err)
+	}
+	s := searches[0]
+	if s.query != "level:3" || s.rangeText != "3600s" || len(s.streamIds) != 2 || s.streamIds[1] != "st2" {
+		t.Errorf("views search = %+v", s)
+	}
+
+	views = false
+	searches, err = fetchServerSearches(opts)
+	if err != nil || len(searches) != 1 {
+		t.Fatalf("fetchServerSearches(legacy) = %v, %v", searches, err)
+	}
+	if s, ok := findServerSearch(searches, "old"); !ok || s.from != "2019-06-01T00:00:00.000Z" || s.streamIds[0] != "st3" {
+		t.Errorf("legacy search = %+v, %v", s, ok)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"30s":        30 * time.Second,
+		"2h":         2 * time.Hour,
+		"3d2h30m":    3*24*time.Hour + 2*time.Hour + 30*time.Minute,
+		"1w":         7 * 24 * time.Hour,
+		"2mo":        60 * 24 * time.Hour,
+		"1y":         365 * 24 * time.Hour,
+		"2 hours":    2 * time.Hour,
+		"1h 15m":     75 * time.Minute,
+		"1.5h":       90 * time.Minute,
+		"PT30M":      30 * time.Minute,
+		"P1DT12H":    36 * time.Hour,
+		"p2w":        14 * 24 * time.Hour,
+		"PT1M30.5S":  90*time.Second + 500*time.Millisecond,
+		"P1M":        30 * 24 * time.Hour,
+		"10 minutes": 10 * time.Minute,
+	}
+	for text, want := range tests {
+		if got, err := parseDuration(text); err != nil || got != want {
+			t.Errorf("parseDuration(%q) = %v, %v, want %v", text, got, err, want)
+		}
+	}
+	for _, invalid := range []string{"", "2x", "2h30", "h", "0m", "PT", "P", "-1h", "2 fortnights"} {
+		if got, err := parseDuration(invalid); err == nil {
+			t.Errorf("parseDuration(%q) = %v, expected an error", invalid, got)
+		}
+	}
+}
+
+func TestParseTimeExpression(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2019, 6, 19, 14, 30, 0, 0, time.UTC)
+	tests := map[string]time.Time{
+		"now":                 now,
+		"now-15m":             now.Add(-15 * time.Minute),
+		"now + 1h":            now.Add(time.Hour),
+		"2 hours ago":         now.Add(-2 * time.Hour),
+		"today":               time.Date(2019, 6, 19, 0, 0, 0, 0, time.UTC),
+		"yesterday 14:00":     time.Date(2019, 6, 18, 14, 0, 0, 0, time.UTC),
+		"tomorrow 9am":        time.Date(2019, 6, 20, 9, 0, 0, 0, time.UTC),
+		"monday":              time.Date(2019, 6, 17, 0, 0, 0, 0, time.UTC),
+		"wednesday":           time.Date(2019, 6, 19, 0, 0, 0, 0, time.UTC),
+		"last wed 12:30pm":    time.Date(2019, 6, 12, 12, 30, 0, 0, time.UTC),
+		"Fri 23:59:59":        time.Date(2019, 6, 14, 23, 59, 59, 0, time.UTC),
+		"1:32pm":              time.Date(2019, 6, 19, 13, 32, 0, 0, time.UTC),
+		"2019-01-04 12:30:00": time.Date(2019, 1, 4, 12, 30, 0, 0, time.UTC),
+	}
+	for text, want := range tests {
+		if got, err := parseTimeExpression(text, now, time.UTC); err != nil || !got.Equal(want) {
+			t.Errorf("parseTimeExpression(%q) = %v, %v, want %v", text, got, err, want)
+		}
+	}
+	for _, invalid := range []string{"now-2x", "yesterday 25:00", "today 13pm", "someday", "last"} {
+		if got, err := parseTimeExpression(invalid, now, time.UTC); err == nil {
+			t.Errorf("parseTimeExpression(%q) = %v, expected an error", invalid, got)
+		}
+	}
+}
+
+func TestFetchMessage(t *testing.T) {
+	var searches []string
+	opts := newTestConfig(t, "", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/search/universal/relative":
+			searches = append(searches, r.URL.Query().Get("query"))
+			fmt.Fprint(w, `{"messages":[{"index":"graylog_7","message":{"_id":"m1"}}]}`)
+		case "/api/messages/graylog_7/m1":
+			fmt.Fprint(w, `{"index":"graylog_7","message":{"_id":"m1","source":"web1",`+
+				`"timestamp":"2019-06-01T12:00:00.000Z","streams":["s1"]}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	for _, id := range []string{"m1", "graylog_7/m1"} {
+		msg := fetchMessage(opts, id)
+		if msg.id != "m1" || msg.fields["source"] != "web1" || len(msg.streams) != 1 ||
+			!msg.timestamp.Equal(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)) {
+			t.Errorf("fetchMessage(%q) = %+v", id, msg)
+		}
+	}
+	if len(searches) != 1 || searches[0] != `_id:"m1"` {
+		t.Errorf("index lookups = %q", searches)
+	}
+}
+
+func TestMessageFields(t *testing.T) {
+	msg := parseMessages([]byte(`{"messages":[{"message":{"_id":"m1","timestamp":"2019-06-01T12:00:00.000Z",` +
+		`"took_ms":12,"ratio":0.5,"cached":true,"tags":["a"],"http":{"status":200},"streams":["s1","s2"]}}]}`))[0]
+	var kinds []string
+	for _, f := range messageFields(msg) {
+		kinds = append(kinds, f.name+":"+f.kind)
+	}
+	expected := "_id:string cached:boolean http:object ratio:double streams:array tags:array timestamp:string took_ms:long"
+	if strings.Join(kinds, " ") != expected {
+		t.Errorf("messageFields = %s", strings.Join(kinds, " "))
+	}
+
+	streams := resolveStreams(msg.streams, map[string]map[string]string{"s1": {"title": "Web"}})
+	if len(streams) != 2 || streams[0].Title != "Web" || streams[1].Title != "s2" {
+		t.Errorf("resolveStreams = %+v", streams)
+	}
+}
+
+func TestBrowser(t *testing.T) {
+	screen := tcell.NewSimulationScreen("")
+	if err := screen.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer screen.Fini()
+	screen.SetSize(80, 25)
+
+	colors, _ := newColorScheme(nil)
+	opts := &options{location: time.UTC, timeFormat: config.DefaultTimeFormat, timeRange: 900, colors: colors,
+		formats: []config.FormatDefinition{{Name: "format", Format: "{{.source}} {{.message}}"}}}
+	b := newBrowser(opts, screen, map[string]map[string]string{})
+	b.addMessages(parseMessages([]byte(`{"messages":[`+
+		`{"message":{"_id":"m1","timestamp":"2019-06-01T12:00:00.000Z","source":"web1","message":"started"}},`+
+		`{"message":{"_id":"m2","timestamp":"2019-06-01T12:00:01.000Z","source":"web2","message":"Timeout"}},`+
+		`{"message":{"_id":"m3","timestamp":"2019-06-01T12:00:02.000Z","source":"web1","message":"stopped"}}]}`)), true)
+	if len(b.visible) != 3 || b.selectedID != "m3" || b.entries[0].text != "web1 started" {
+		t.Fatalf("loaded %+v, selected %s", b.entries, b.selectedID)
+	}
+
+	keys := func(text string) {
+		for _, r := range text {
+			b.handleKey(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+		}
+	}
+	keys("/web1")
+	if b.mode != uiFilter || len(b.visible) != 2 || b.selectedID != "m3" {
+		t.Errorf("filter web1: %v, selected %s", b.visible, b.selectedID)
+	}
+	b.handleKey(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	keys("k")
+	if b.mode != uiBrowse || b.filter != "web1" || b.selectedID != "m1" {
+		t.Errorf("after filter: mode %d, filter %q, selected %s", b.mode, b.filter, b.selectedID)
+	}
+	b.handleKey(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone))
+	if len(b.visible) != 3 || b.selectedID != "m1" {
+		t.Errorf("cleared filter: %v, selected %s", b.visible, b.selectedID)
+	}
+	b.draw()
+
+	segments := parseANSI("\x1b[1;31mERROR\x1b[0m ok", tcell.StyleDefault)
+	fg, _, attrs := segments[0].style.Decompose()
+	if len(segments) != 2 || segments[0].text != "ERROR" || fg != tcell.PaletteColor(1) ||
+		attrs&tcell.AttrBold == 0 || segments[1].style != tcell.StyleDefault {
+		t.Errorf("parseANSI = %+v", segments)
+	}
+	if rangeLabel(3600) != "1h" || rangeLabel(90) != "90s" {
+		t.Errorf("rangeLabel(3600) = %s, rangeLabel(90) = %s", rangeLabel(3600), rangeLabel(90))
+	}
+}
+
+func TestTailStats(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	message := func(ago time.Duration, level string, source string) logMessage {
+		return logMessage{timestamp: now.Add(-ago), fields: map[string]string{logLevelField: level, sourceField: source}}
+	}
+	stats := tailStats{started: now.Add(-time.Hour)}
+	stats.add(now, []logMessage{
+		message(10*time.Minute, "ERROR", "old"),
+		message(2*time.Minute, "INFO", "web1"),
+		message(30*time.Second, "3", "web2"),
+		message(5*time.Second, "INFO", "web1"),
+		message(time.Second, "WARN", "web1"),
+	})
+	if len(stats.events) != 4 || stats.total != 5 {
+		t.Errorf("events = %+v, total %d", stats.events, stats.total)
+	}
+	if rate := stats.rate(now, 10*time.Second); rate != 0.2 {
+		t.Errorf("10s rate = %v", rate)
+	}
+	if rate := stats.rate(now, time.Minute); rate != 0.05 {
+		t.Errorf("1m rate = %v", rate)
+	}
+	lines := stats.render(now, nil, false)
+	if lines[2] != "levels   INFO 2  ERROR 1  WARN 1" || lines[3] != "sources  web1 3  web2 1" {
+		t.Errorf("render = %q", lines)
+	}
+}
+
+func TestTailQueries(t *testing.T) {
+	opts := newTestConfig(t, "[formats]\nshort: {{.message}}\n\n"+
+		"[searches.payments]\nquery: application:payments AND loglevel:WARN\nrange: 1h\nformat: short\n", nil)
+	opts.colors, _ = newColorScheme(nil)
+	opts.query, opts.timeRange, opts.location = "env:prod", 300, time.UTC
+	opts.formats = []config.FormatDefinition{{Name: "full", Format: "{{.source}} {{.message}}"}}
+
+	queries, err := newTailQueries(opts, []string{"errors=application:checkout AND level:3"}, []string{"payments"})
+	if err != nil || len(queries) != 2 {
+		t.Fatalf("newTailQueries() = %v, %v", queries, err)
+	}
+	if queries[0].opts.query != "(env:prod) AND (application:checkout AND level:3)" || queries[0].prefix != "[errors]   " {
+		t.Errorf("errors query = %q, prefix %q", queries[0].opts.query, queries[0].prefix)
+	}
+	payments := queries[1]
+	if payments.opts.timeRange != 3600 || payments.opts.formats[0].Name != "short" || opts.timeRange != 300 {
+		t.Errorf("payments range = %d, formats %v", payments.opts.timeRange, payments.opts.formats)
+	}
+
+	var out bytes.Buffer
+	msg := logMessage{fields: map[string]string{"source": "web1", "message": "card declined"}}
+	printTailBatch(&out, payments, nil, []logMessage{msg})
+	if out.String() != "[payments] card declined\n" {
+		t.Errorf("printTailBatch() = %q", out.String())
+	}
+
+	if _, err := newTailQueries(opts, []string{"level:3"}, nil); err == nil {
+		t.Error("newTailQueries() accepted a query without a name")
+	}
+}
+
+func TestMatchActions(t *testing.T) {
+	for _, spec := range []string{`loglevel == "ERROR"`, `loglevel == "ERROR" => page`, `exists panic => run`,
+		`exists panic => bell now`, `loglevel == => bell`} {
+		if _, err := parseMatchRule(spec); err == nil {
+			t.Errorf("parseMatchRule(%q) accepted an invalid rule", spec)
+		}
+	}
+
+	actions, err := newMatchActions([]string{`source == "web1" and took_ms > 100 => run cat > "$OUT"; echo "$GRAYLOG_SOURCE $GRAYLOG_SUPPRESSED" >> "$OUT"`}, time.Minute, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := actions.rules[0]
+	if rule.kind != actionRun || rule.target != `cat > "$OUT"; echo "$GRAYLOG_SOURCE $GRAYLOG_SUPPRESSED" >> "$OUT"` {
+		t.Errorf("rule = %+v", rule)
+	}
+
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	var allowed []string
+	for _, at := range []time.Duration{0, 10 * time.Second, 30 * time.Second, 70 * time.Second, 140 * time.Second} {
+		suppressed, ok := actions.allow(rule, now.Add(at))
+		allowed = append(allowed, fmt.Sprintf("%v/%d", ok, suppressed))
+	}
+	if strings.Join(allowed, " ") != "true/0 false/0 false/0 true/2 true/0" {
+		t.Errorf("allowed = %v", allowed)
+	}
+	if _, ok := actions.allow(&matchRule{}, now.Add(150*time.Second)); !ok {
+		t.Error("allow() refused a first match")
+	}
+	if _, ok := actions.allow(&matchRule{}, now.Add(160*time.Second)); ok {
+		t.Error("allow() exceeded the actions per minute")
+	}
+
+	// Messages logged before the tail started are ignored, and a match skipped while every slot is taken doesn't
+	// start the cool-down
+	busy, _ := newMatchActions([]string{`source == "web1" => bell`}, time.Minute, 2)
+	for i := 0; i < maxRunningActions; i++ {
+		busy.running <- struct{}{}
+	}
+	opts := &options{}
+	busy.handle(opts, []logMessage{{timestamp: busy.since.Add(-time.Second), fields: map[string]string{"source": "web1"}}})
+	if busy.rules[0].suppressed != 0 {
+		t.Errorf("handle() counted a message older than the tail")
+	}
+	busy.handle(opts, []logMessage{{timestamp: busy.since.Add(time.Second), fields: map[string]string{"source": "web1"}}})
+	if busy.rules[0].suppressed != 1 || !busy.rules[0].last.IsZero() || len(busy.started) > 0 {
+		t.Errorf("handle() with no free slot: rule = %+v, started = %v", busy.rules[0], busy.started)
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+	os.Setenv("OUT", out)
+	defer os.Unsetenv("OUT")
+	msg := logMessage{fields: map[string]string{"source": "web1", "took_ms": "250"}}
+	if err := rule.execute(msg, 3); err != nil {
+		t.Fatal(err)
+	}
+	written, _ := ioutil.ReadFile(out)
+	if string(written) != `{"source":"web1","took_ms":"250"}web1 3`+"\n" {
+		t.Errorf("run action wrote %q", written)
+	}
+
+	var posted []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	webhook, _ := parseMatchRule("took_ms > 100 => post " + server.URL)
+	if err := webhook.execute(msg, 0); err != nil {
+		t.Fatal(err)
+	}
+	if string(posted) != `{"condition":"took_ms \u003e 100","message":{"source":"web1","took_ms":"250"},"suppressed":0}` {
+		t.Errorf("post action sent %s", posted)
+	}
+}
+
+func TestMessageAPIURIInUTC(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone data")
+	}
+	start, err := parseTimeExpression("10:00", time.Date(2019, 6, 1, 12, 0, 0, 0, loc), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := start.Add(time.Hour)
+	uri, _ := messageAPIURI(&options{startDate: &start, endDate: &end, location: loc})
+	if !strings.Contains(uri, "from=2019-06-01+14%3A00%3A00.000&to=2019-06-01+15%3A00%3A00.000") {
+		t.Errorf("messageAPIURI() = %s", uri)
+	}
+}
+
+func TestExportWindowInUTC(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip("no time zone data")
+	}
+	// A relative --export window, as of now in the --tz location
+	now := time.Now().In(loc)
+	from := now.Add(-time.Hour)
+	opts := &options{startDate: &from, endDate: &now, location: loc}
+	uri, _ := messageAPIURI(opts)
+	expected := "from=" + url.QueryEscape(from.UTC().Format(graylogInputTimeFormat)) +
+		"&to=" + url.QueryEscape(now.UTC().Format(graylogInputTimeFormat))
+	if !strings.Contains(uri, expected) {
+		t.Errorf("messageAPIURI() = %s, expected %s", uri, expected)
+	}
+
+	chunk := exportChunk{time.Date(2019, 6, 1, 9, 0, 0, 0, loc), time.Date(2019, 6, 1, 10, 0, 0, 0, loc)}
+	chunkOpts := chunkOptions(opts, chunk)
+	uri, _ = messageAPIURI(&chunkOpts)
+	if !strings.Contains(uri, "from=2019-06-01+00%3A00%3A00.000&to=2019-06-01+00%3A59%3A59.999") {
+		t.Errorf("chunk URI = %s", uri)
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/buger/jsonparser"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -30,7 +33,8 @@ func getJSONString(data []byte, keys ...string) string {
 		fmt.Fprintf(os.Stderr, "Unable to retrieve string for keys: %v - %s\n", keys, string(err.Error()))
 		return ""
 	}
-	return Expand(value)
+	// GetString has already decoded the escape sequences
+	return value
 }
 
 // Retrieve an array structure from the json buffer.
@@ -46,36 +50,90 @@ func getJSONArray(data []byte, keys ...string) []byte {
 	return []byte{}
 }
 
-// Retrieve a parsed array of strings from the json buffer.
-func getJSONArrayOfStrings(data []byte, keys ...string) []string {
-	arraySlice := getJSONArray(data, keys...)
-	var stringList []string
-	_, _ = jsonparser.ArrayEach(arraySlice, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
-		if dataType == jsonparser.String || dataType == jsonparser.Number || dataType == jsonparser.Boolean {
-			stringList = append(stringList, Expand(string(value)))
-		}
-	})
-	return stringList
-}
-
 // Retrieve a parsed map of values from the json buffer. Numbers and booleans are converted to strings.
 func getJSONSimpleMap(data []byte, keys ...string) map[string]string {
 	result := make(map[string]string)
 	_ = jsonparser.ObjectEach(data, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
-		if dataType == jsonparser.String || dataType == jsonparser.Number || dataType == jsonparser.Boolean {
+		switch dataType {
+		case jsonparser.String:
 			result[string(key)] = Expand(string(value))
+		case jsonparser.Number, jsonparser.Boolean:
+			result[string(key)] = string(value)
 		}
 		return nil
 	}, keys...)
 	return result
 }
 
-// Expand escape strings. JSON strings from Graylog have embedded escape sequences that aren't getting expanded. We
-// have to do it manually.
+// Decode the messages of a search response, keeping the type of each value. Numbers keep their original text.
+func decodeMessages(data []byte) ([]map[string]interface{}, error) {
+	var response struct {
+		Messages []struct {
+			Message map[string]interface{} `json:"message"`
+		} `json:"messages"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&response); err != nil {
+		return nil, err
+	}
+
+	var messages []map[string]interface{}
+	for _, m := range response.Messages {
+		if m.Message != nil {
+			messages = append(messages, m.Message)
+		}
+	}
+	return messages, nil
+}
+
+// Flatten decoded values into strings. Nested objects also get an entry per value, keyed by their dotted path (e.g.,
+// http.status), objects and arrays themselves are rendered as JSON. Null values are left out.
+func flattenValues(values map[string]interface{}) map[string]string {
+	result := make(map[string]string)
+	for k, v := range values {
+		flattenValue(result, k, v)
+	}
+	return result
+}
+
+func flattenValue(result map[string]string, key string, value interface{}) {
+	if value == nil {
+		return
+	}
+	result[key] = valueString(value)
+	if v, ok := value.(map[string]interface{}); ok {
+		for k, nested := range v {
+			flattenValue(result, key+"."+k, nested)
+		}
+	}
+}
+
+// The string form of a value in the fields of a message. Objects and arrays are kept as JSON.
+func valueString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		buf, _ := json.Marshal(v)
+		return string(buf)
+	}
+}
+
+// Expand escape strings. jsonparser returns the raw bytes of JSON strings, with their escape sequences (\n, \",
+// \\, \u00e9, ...) still in place. They're decoded following the JSON rules, so an escaped backslash followed by an
+// 'n' stays a literal backslash-n. Values that aren't valid JSON string contents are returned unchanged.
 func Expand(value string) string {
-	var result string
-	result = strings.Replace(value, "\\n", "\n", -1)
-	result = strings.Replace(result, "\\r", "\r", -1)
-	result = strings.Replace(result, "\\t", "\t", -1)
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+	result, err := jsonparser.ParseString([]byte(value))
+	if err != nil {
+		return value
+	}
 	return result
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/briandowns/spinner"
 	"os"
 	"os/signal"
@@ -38,7 +40,11 @@ func delayForSeconds(delay float64) {
 	time.Sleep(time.Duration(delayInMilliseconds) * time.Millisecond)
 }
 
-func setupSpinner() *spinner.Spinner {
+// Create the spinner shown while tailing. Returns nil when the spinner is disabled, e.g., stderr isn't a terminal.
+func setupSpinner(enabled bool) *spinner.Spinner {
+	if !enabled {
+		return nil
+	}
 	s := spinner.New(spinner.CharSets[9], 100*time.Millisecond)
 	s.UpdateCharSet(spinner.CharSets[21]) // box of dots
 	s.Writer = os.Stderr
@@ -47,6 +53,18 @@ func setupSpinner() *spinner.Spinner {
 	return s
 }
 
+func startSpinner(s *spinner.Spinner) {
+	if s != nil {
+		s.Start()
+	}
+}
+
+func stopSpinner(s *spinner.Spinner) {
+	if s != nil {
+		s.Stop()
+	}
+}
+
 func makeSignalsChannel() chan os.Signal {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c,
@@ -63,27 +81,72 @@ func makeSignalsChannel() chan os.Signal {
 func main() {
 	opts := parseArgs()
 
+	switch opts.command {
+	case saveCommand:
+		commandSave(opts)
+		os.Exit(0)
+	case searchesListCommand:
+		commandListSearches(opts)
+		os.Exit(0)
+	case searchesSaveCommand:
+		commandSaveSearch(opts)
+		os.Exit(0)
+	case contextCommand:
+		commandContext(opts)
+		os.Exit(0)
+	case showCommand:
+		commandShow(opts)
+		os.Exit(0)
+	case uiCommand:
+		commandUI(opts)
+		os.Exit(0)
+	}
+
 	if opts.listStreams {
 		streams := fetchStreams(opts)
 		commandListStreams(streams)
 		os.Exit(0)
 	}
 
+	if len(opts.tailQueries) > 0 {
+		commandMultiTail(opts)
+	}
+
 	if !opts.tail {
 		messages, streams := commandListMessages(opts)
-		printMessages(messages, opts, streams)
+		var output bytes.Buffer
+		printMessages(&output, messages, opts, streams)
+		writePaged(&output, opts.pager)
 	} else {
 		var delay = minDelay
 
-		s := setupSpinner()
-		s.Start()
+		var rec *recorder
+		if opts.record {
+			var err error
+			if rec, err = newRecorder(opts); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to record to '%s': %s\n", opts.exportPath, err.Error())
+				os.Exit(1)
+			}
+		}
+
+		var panel *statsPanel
+		if opts.stats {
+			panel = newStatsPanel(opts)
+			panel.start()
+		}
+
+		s := setupSpinner(opts.spinner)
+		startSpinner(s)
 
 		exitChan := makeSignalsChannel()
 
 		// Handle exit signals - only needed when tailing
 		go func() {
 			for _ = range exitChan {
-				s.Stop()
+				stopSpinner(s)
+				if rec != nil {
+					_ = rec.Close()
+				}
 				os.Exit(0)
 			}
 		}()
@@ -91,10 +154,21 @@ func main() {
 		//noinspection GoInfiniteFor
 		for {
 			messages, streams := commandListMessages(opts)
-			if len(messages) > 0 {
-				s.Stop()
-				printMessages(messages, opts, streams)
-				s.Start()
+			if rec != nil {
+				if err := rec.record(opts, streams, messages); err != nil {
+					stopSpinner(s)
+					fmt.Fprintf(os.Stderr, "Unable to record to '%s': %s\n", opts.exportPath, err.Error())
+					os.Exit(1)
+				}
+			} else if panel != nil {
+				panel.add(opts, streams, messages)
+			} else if len(messages) > 0 {
+				stopSpinner(s)
+				printMessages(os.Stdout, messages, opts, streams)
+				startSpinner(s)
+			}
+			if opts.actions != nil {
+				opts.actions.handle(opts, messages)
 			}
 
 			delayForSeconds(delay)
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// Colors of the query names prefixed to the lines of a multi-query tail, in the order of the queries.
+var tailQueryColors = []string{"cyan", "magenta", "yellow", "green", "blue", "bright-cyan", "bright-magenta", "bright-yellow"}
+
+// The name of a --tail-query, e.g., 'errors' in 'errors=level:ERROR'.
+var tailQueryNameRegex = regexp.MustCompile(`^([\w.-]+)=(.+)$`)
+
+// Number of message ids remembered per query to skip the messages already printed.
+const tailSeenSize = 1024
+
+// tailQuery is one of the searches of a multi-query tail, with its own settings and back-off.
+type tailQuery struct {
+	name   string
+	opts   *options
+	prefix string
+	seen   *lru.Cache
+}
+
+// A batch of new messages found by a query.
+type tailBatch struct {
+	query    *tailQuery
+	messages []logMessage
+}
+
+// Create the queries of a multi-query tail from the --tail-query specs and the --tail-search saved searches. The other
+// flags (-q, -s, --where, ...) apply to every query: the -q query is combined with each query using 'AND'.
+func newTailQueries(opts *options, specs []string, searchNames []string) ([]*tailQuery, error) {
+	var queries []*tailQuery
+	add := func(name string, query string) *tailQuery {
+		q := &tailQuery{name: name}
+		queryOpts := *opts
+		q.opts = &queryOpts
+		q.opts.query = query
+		if len(opts.query) > 0 {
+			q.opts.query = "(" + opts.query + ") AND (" + query + ")"
+		}
+		q.seen, _ = lru.New(tailSeenSize)
+		queries = append(queries, q)
+		return q
+	}
+
+	for _, spec := range specs {
+		m := tailQueryNameRegex.FindStringSubmatch(strings.TrimSpace(spec))
+		if m == nil {
+			return nil, fmt.Errorf("expected <name>=<query>, found '%s'", spec)
+		}
+		add(m[1], m[2])
+	}
+
+	var serverSearches []serverSearch
+	for _, name := range searchNames {
+		if saved, ok := opts.serverConfig.Search(name); ok {
+			saved, err := expandSearch(saved, nil)
+			if err != nil {
+				return nil, err
+			}
+			q := add(name, saved.Query)
+			if len(saved.Streams) > 0 {
+				if q.opts.streamIds = findStreamIds(opts, saved.Streams); q.opts.streamIds == nil {
+					return nil, fmt.Errorf("the saved search '%s' has invalid stream name(s) '%s'", name, saved.Streams)
+				}
+			}
+			if len(saved.Range) > 0 {
+				d, err := parseDuration(saved.Range)
+				if err != nil {
+					return nil, fmt.Errorf("the saved search '%s' has an invalid range: %s", name, err.Error())
+				}
+				q.opts.timeRange = int(d.Seconds())
+			}
+			if len(saved.Format) > 0 {
+				if q.opts.formats, err = selectFormats(opts.serverConfig, saved.Format); err != nil {
+					return nil, fmt.Errorf("the saved search '%s' has an invalid format: %s", name, err.Error())
+				}
+			}
+			if len(saved.Columns) > 0 {
+				q.opts.columns = exportFields(saved.Columns)
+			}
+			continue
+		}
+
+		if serverSearches == nil {
+			var err error
+			if serverSearches, err = fetchServerSearches(opts); err != nil {
+				return nil, fmt.Errorf("no saved search named '%s' in the config file, and Graylog's saved searches "+
+					"can't be fetched: %s", name, err.Error())
+			}
+		}
+		saved, ok := findServerSearch(serverSearches, name)
+		if !ok {
+			return nil, fmt.Errorf("no saved search named '%s', see 'graylog searches list'", name)
+		}
+		q := add(name, saved.query)
+		if len(saved.streamIds) > 0 {
+			q.opts.streamIds = saved.streamIds
+		}
+		if len(saved.rangeText) > 0 {
+			d, _ := parseDuration(saved.rangeText)
+			q.opts.timeRange = int(d.Seconds())
+		}
+	}
+
+	width := 0
+	for _, q := range queries {
+		if len(q.name) > width {
+			width = len(q.name)
+		}
+	}
+	for i, q := range queries {
+		q.prefix = fmt.Sprintf("%-*s ", width+2, "["+q.name+"]")
+		if opts.useColors {
+			esc, _ := parseColor(tailQueryColors[i%len(tailQueryColors)])
+			q.prefix = esc + q.prefix + resetEsc
+		}
+	}
+	return queries, nil
+}
+
+// Tail several queries at once. Each query is polled on its own, backing off while it finds no new messages. A
+// message found by more than one query is printed once, prefixed by the name of the query that found it first.
+func commandMultiTail(opts *options) {
+	streams := fetchStreams(opts)
+	batches := make(chan tailBatch)
+	for _, q := range opts.tailQueries {
+		go q.poll(batches)
+	}
+
+	s := setupSpinner(opts.spinner)
+	startSpinner(s)
+	exitChan := makeSignalsChannel()
+	go func() {
+		for range exitChan {
+			stopSpinner(s)
+			os.Exit(0)
+		}
+	}()
+
+	printed, _ := lru.New(tailSeenSize * len(opts.tailQueries))
+	for batch := range batches {
+		var messages []logMessage
+		for _, msg := range batch.messages {
+			if !printed.Contains(msg.id) {
+				printed.Add(msg.id, true)
+				messages = append(messages, msg)
+			}
+		}
+		if len(messages) > 0 {
+			stopSpinner(s)
+			printTailBatch(os.Stdout, batch.query, streams, messages)
+			startSpinner(s)
+			if opts.actions != nil {
+				opts.actions.handle(opts, messages)
+			}
+		}
+	}
+}
+
+// Poll a query forever, sending the messages it didn't find before.
+func (q *tailQuery) poll(batches chan<- tailBatch) {
+	delay := minDelay
+	for {
+		api, _ := messageAPIURI(q.opts)
+		var found []logMessage
+		for _, msg := range parseMessages(callGraylog(q.opts, api, jsonAcceptType)) {
+			if !q.seen.Contains(msg.id) {
+				q.seen.Add(msg.id, true)
+				found = append(found, msg)
+			}
+		}
+		if len(found) > 0 {
+			batches <- tailBatch{q, found}
+		}
+
+		delayForSeconds(delay)
+		delay = adjustDelay(delay, found)
+	}
+}
+
+// Print messages with the format of their query, prefixing every line with the name of the query.
+func printTailBatch(w io.Writer, q *tailQuery, streams map[string]map[string]string, messages []logMessage) {
+	var buf bytes.Buffer
+	printMessages(&buf, messages, q.opts, streams)
+	scanner := bufio.NewScanner(&buf)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		fmt.Fprintln(w, q.prefix+scanner.Text())
+	}
+}
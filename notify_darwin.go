@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// The command showing a desktop notification for the notify action of --on-match.
+func notifyCommand(title string, text string) *exec.Cmd {
+	script := "display notification " + strconv.Quote(text) + " with title " + strconv.Quote(title)
+	return exec.Command("osascript", "-e", script)
+}
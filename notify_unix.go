@@ -0,0 +1,11 @@
+//go:build linux || dragonfly || freebsd || netbsd || openbsd
+// +build linux dragonfly freebsd netbsd openbsd
+
+package main
+
+import "os/exec"
+
+// The command showing a desktop notification for the notify action of --on-match.
+func notifyCommand(title string, text string) *exec.Cmd {
+	return exec.Command("notify-send", title, text)
+}
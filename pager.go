@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/sys/unix"
+)
+
+// Pager used when $PAGER isn't set. The -R option lets the color escapes through.
+const defaultPager = "less -R"
+
+// Matches ANSI escape sequences, which take no space on the screen.
+var ansiEscapeRegex = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// Write the output to stdout, sending it through the pager when it doesn't fit on the screen.
+func writePaged(output *bytes.Buffer, usePager bool) {
+	if usePager {
+		rows, cols, ok := terminalSize(os.Stdout)
+		if ok && screenLines(output.String(), cols) > rows {
+			if err := runPager(output); err == nil {
+				return
+			}
+		}
+	}
+	_, _ = output.WriteTo(os.Stdout)
+}
+
+// Pipe the output through $PAGER (or less).
+func runPager(output *bytes.Buffer) error {
+	pager := strings.TrimSpace(os.Getenv("PAGER"))
+	if len(pager) == 0 {
+		pager = defaultPager
+	}
+	if pager == "cat" {
+		_, err := output.WriteTo(os.Stdout)
+		return err
+	}
+
+	// The output is kept in the buffer, so it can still be written if the pager fails
+	input := bytes.NewReader(output.Bytes())
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin = input
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		// The user quitting early isn't worth reporting. A pager that couldn't be started, or that failed before
+		// reading anything (e.g., the shell's 127 for a missing command), means the output still has to be written.
+		exitErr, ok := err.(*exec.ExitError)
+		if ok && exitErr.ExitCode() != 127 && input.Len() < output.Len() {
+			output.Reset()
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "Unable to run pager '%s': %s\n", pager, err.Error())
+		return err
+	}
+	output.Reset()
+	return nil
+}
+
+// Get the size of the terminal in rows and columns.
+func terminalSize(f *os.File) (rows int, cols int, ok bool) {
+	ws, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Row == 0 {
+		return 0, 0, false
+	}
+	return int(ws.Row), int(ws.Col), true
+}
+
+// Count the number of screen lines the text takes up, including wrapped lines.
+func screenLines(text string, cols int) int {
+	var count int
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		width := utf8.RuneCountInString(ansiEscapeRegex.ReplaceAllString(line, ""))
+		if cols > 0 && width > cols {
+			count += (width + cols - 1) / cols
+		} else {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Levels in order of severity. Levels in the same group are equally severe.
+var levelSeverity = [][]string{
+	{traceLevel},
+	{debugLevel},
+	{infoLevel},
+	{noticeLevel},
+	{warnLevel, "WARNING"},
+	{errorLevel},
+	{criticalLevel, fatalLevel},
+	{alertLevel},
+	{emergencyLevel},
+}
+
+// Characters with a special meaning in the Elasticsearch query syntax.
+const querySpecialChars = `+-=&|><!(){}[]^"~*?:\/ `
+
+// queryTerms holds the query builder flags.
+type queryTerms struct {
+	query       string
+	application string
+	fields      []string
+	not         []string
+	level       string
+	sources     []string
+	exists      []string
+}
+
+// Combine the -q query and the query builder flags with 'AND'. Repeated --source flags, and repeated --field flags
+// for the same field, match any of their values.
+func buildQuery(terms queryTerms) (string, error) {
+	var parts []string
+	if len(terms.query) > 0 {
+		parts = append(parts, "("+terms.query+")")
+	}
+	if len(terms.application) > 0 {
+		parts = append(parts, fieldTerm("application", terms.application))
+	}
+	if len(terms.sources) > 0 {
+		var sources []string
+		for _, s := range terms.sources {
+			sources = append(sources, fieldTerm("source", s))
+		}
+		parts = append(parts, anyOf(sources))
+	}
+
+	var keys []string
+	values := make(map[string][]string)
+	for _, f := range terms.fields {
+		key, value, err := splitFieldTerm(f, "--field")
+		if err != nil {
+			return "", err
+		}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = append(values[key], fieldTerm(key, value))
+	}
+	for _, key := range keys {
+		parts = append(parts, anyOf(values[key]))
+	}
+
+	for _, f := range terms.exists {
+		parts = append(parts, "_exists_:"+escapeQueryTerm(f))
+	}
+
+	if len(terms.level) > 0 {
+		levels, err := levelQuery(terms.level)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, levels)
+	}
+
+	var positive = len(parts) > 0
+	for _, f := range terms.not {
+		key, value, err := splitFieldTerm(f, "--not")
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, "NOT "+fieldTerm(key, value))
+	}
+	if !positive && len(parts) > 0 {
+		// Elasticsearch needs something to subtract the NOT terms from
+		parts = append([]string{"*"}, parts...)
+	}
+
+	return strings.Join(parts, " AND "), nil
+}
+
+// Split a 'key=value' flag value.
+func splitFieldTerm(term string, flag string) (key string, value string, err error) {
+	i := strings.Index(term, "=")
+	if i <= 0 {
+		return "", "", fmt.Errorf("%s expects key=value, got '%s'", flag, term)
+	}
+	return term[:i], term[i+1:], nil
+}
+
+// Create a 'field:value' term. Values are quoted, unless they contain wildcards (* or ?), in which case everything
+// else is escaped so the wildcards still apply.
+func fieldTerm(field string, value string) string {
+	if strings.ContainsAny(value, "*?") {
+		var escaped strings.Builder
+		for _, r := range value {
+			if r != '*' && r != '?' && strings.ContainsRune(querySpecialChars, r) {
+				escaped.WriteRune('\\')
+			}
+			escaped.WriteRune(r)
+		}
+		return escapeQueryTerm(field) + ":" + escaped.String()
+	}
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return escapeQueryTerm(field) + `:"` + value + `"`
+}
+
+// Escape the special characters in a term, e.g., a field name.
+func escapeQueryTerm(term string) string {
+	var escaped strings.Builder
+	for _, r := range term {
+		if strings.ContainsRune(querySpecialChars, r) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+// Combine terms with 'OR', in parentheses when there's more than one.
+func anyOf(terms []string) string {
+	if len(terms) == 1 {
+		return terms[0]
+	}
+	return "(" + strings.Join(terms, " OR ") + ")"
+}
+
+// Expand a --level expression, e.g., '>=WARN', 'ERROR' or '<info', into the level names (loglevel field) and syslog
+// severities (level field) that normalizeLevel understands.
+func levelQuery(expression string) (string, error) {
+	op := strings.TrimRight(expression, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz")
+	name := strings.ToUpper(strings.TrimSpace(expression[len(op):]))
+	op = strings.TrimSpace(op)
+
+	severity := -1
+	for i, group := range levelSeverity {
+		for _, level := range group {
+			if level == name {
+				severity = i
+			}
+		}
+	}
+	if severity < 0 {
+		return "", fmt.Errorf("unknown level '%s'", name)
+	}
+
+	var matches func(int) bool
+	switch op {
+	case "", "=", "==":
+		matches = func(i int) bool { return i == severity }
+	case ">=":
+		matches = func(i int) bool { return i >= severity }
+	case ">":
+		matches = func(i int) bool { return i > severity }
+	case "<=":
+		matches = func(i int) bool { return i <= severity }
+	case "<":
+		matches = func(i int) bool { return i < severity }
+	default:
+		return "", fmt.Errorf("unknown level comparison '%s'", op)
+	}
+
+	var names []string
+	var numbers []string
+	for i, group := range levelSeverity {
+		if !matches(i) {
+			continue
+		}
+		for _, level := range group {
+			names = append(names, level, strings.ToLower(level))
+		}
+	}
+	for number, level := range syslogLevels {
+		for i, group := range levelSeverity {
+			if group[0] == level && matches(i) {
+				numbers = append(numbers, number)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("no level is %s%s", op, name)
+	}
+	sort.Strings(numbers)
+
+	terms := []string{logLevelField + ":(" + strings.Join(names, " OR ") + ")"}
+	if len(numbers) > 0 {
+		terms = append(terms, levelField+":("+strings.Join(numbers, " OR ")+")")
+	}
+	return anyOf(terms), nil
+}
+
+// Print the final query and the search URL on stderr.
+func printQuery(opts *options) {
+	fmt.Fprintf(os.Stderr, "Query: %s\n", opts.query)
+	if len(opts.archivePath) == 0 {
+		api, _ := messageAPIURI(opts)
+		fmt.Fprintf(os.Stderr, "URL:   %s/%s\n", opts.serverConfig.Uri(), api)
+	}
+}
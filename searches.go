@@ -0,0 +1,126 @@
+package main
+
+import (
+	"./config"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Fill in the {{.arg1}}, {{.arg2}}, ... placeholders of a saved search with the arguments given to the run command.
+func expandSearch(search config.SavedSearch, args []string) (config.SavedSearch, error) {
+	data := make(map[string]string, len(args))
+	for i, arg := range args {
+		data["arg"+strconv.Itoa(i+1)] = arg
+	}
+
+	var err error
+	expand := func(value string) string {
+		if err != nil || !strings.Contains(value, "{{") {
+			return value
+		}
+		var t *template.Template
+		if t, err = template.New(search.Name).Option("missingkey=error").Parse(value); err != nil {
+			return value
+		}
+		var result bytes.Buffer
+		if err = t.Execute(&result, data); err != nil {
+			err = fmt.Errorf("the saved search '%s' needs more arguments: %s", search.Name, value)
+			return value
+		}
+		return result.String()
+	}
+
+	search.Query = expand(search.Query)
+	search.Streams = expand(search.Streams)
+	search.Range = expand(search.Range)
+	search.Format = expand(search.Format)
+	search.Columns = expand(search.Columns)
+	return search, err
+}
+
+// A setting of a saved search, as listed by 'searches list'.
+type searchSetting struct {
+	name  string
+	value string
+}
+
+// Print the saved searches defined in the config file, followed by the ones saved in Graylog.
+func commandListSearches(opts *options) {
+	searches := opts.serverConfig.Searches()
+	for _, s := range searches {
+		printBoldText(s.Name)
+		printSearchSettings([]searchSetting{
+			{"query", s.Query},
+			{"streams", s.Streams},
+			{"range", s.Range},
+			{"format", s.Format},
+			{"columns", s.Columns},
+		})
+	}
+
+	serverSearches, err := fetchServerSearches(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to fetch the saved searches from Graylog: %s\n", err.Error())
+	} else if len(serverSearches) > 0 {
+		printServerSearches(serverSearches, fetchStreams(opts))
+	}
+
+	if len(searches) == 0 && len(serverSearches) == 0 {
+		fmt.Fprintln(os.Stderr, "No saved searches, add one with 'graylog searches save <name> -q ...'")
+	}
+}
+
+// Print the settings that have a value, indented below the name of the search.
+func printSearchSettings(settings []searchSetting) {
+	for _, setting := range settings {
+		if len(setting.value) > 0 {
+			fmt.Printf("  %-8s %s\n", setting.name+":", setting.value)
+		}
+	}
+}
+
+// Save the current search flags into the config file as a named search.
+func commandSaveSearch(opts *options) {
+	search := opts.search
+	search.Name = opts.commandArgs[0]
+	if err := opts.serverConfig.SaveSearch(search); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to save the search '%s': %s\n", search.Name, err.Error())
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Saved the search '%s' to %s, run it with 'graylog run %s'\n",
+		search.Name, opts.configPath, search.Name)
+}
+
+// Pick the formats used to display messages: a single format from the [formats] section, a template given on the
+// command-line, or all the configured formats.
+func selectFormats(cfg *config.IniFile, format string) ([]config.FormatDefinition, error) {
+	formats := cfg.Formats()
+	if len(format) == 0 {
+		return formats, nil
+	}
+	if strings.Contains(format, "{{") {
+		if _, err := template.New("format").Funcs(templateFuncs).Parse(format); err != nil {
+			return nil, err
+		}
+		return []config.FormatDefinition{{Name: "format", Format: format}}, nil
+	}
+	for _, f := range formats {
+		if f.Name == format {
+			return []config.FormatDefinition{f}, nil
+		}
+	}
+	return nil, fmt.Errorf("no format named '%s' in the [formats] section", format)
+}
+
+// Render the values of the columns of a message, separated by tabs.
+func columnsLine(columns []string, msg logMessage) string {
+	values := make([]string, len(columns))
+	for i, c := range columns {
+		values[i] = msg.fields[c]
+	}
+	return strings.Join(values, "\t")
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const viewsInfo = "views?per_page=%d&page=%d"
+const viewSearchInfo = "views/search/%s"
+const legacySavedSearches = "search/saved"
+
+// Number of views requested at a time.
+const viewsPageSize = 100
+
+// serverSearch is a saved search stored in Graylog, translated into the CLI's terms.
+type serverSearch struct {
+	title     string
+	query     string
+	rangeText string
+	from      string
+	to        string
+	keyword   string
+	streamIds []string
+	fields    string
+}
+
+// Graylog time ranges, used by both the views API and the legacy saved searches.
+type graylogTimeRange struct {
+	Type    string          `json:"type"`
+	Range   json.Number     `json:"range"`
+	From    json.RawMessage `json:"from"`
+	To      json.RawMessage `json:"to"`
+	Keyword string          `json:"keyword"`
+}
+
+type graylogViews struct {
+	Views []struct {
+		Type     string `json:"type"`
+		Title    string `json:"title"`
+		SearchID string `json:"search_id"`
+	} `json:"views"`
+}
+
+type graylogViewSearch struct {
+	Queries []struct {
+		Query struct {
+			QueryString string `json:"query_string"`
+		} `json:"query"`
+		Timerange graylogTimeRange `json:"timerange"`
+		Filter    *graylogFilter   `json:"filter"`
+	} `json:"queries"`
+}
+
+type graylogFilter struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id"`
+	Filters []graylogFilter `json:"filters"`
+}
+
+type graylogSavedSearches struct {
+	Searches []struct {
+		Title string `json:"title"`
+		Query struct {
+			Query     string      `json:"query"`
+			RangeType string      `json:"rangeType"`
+			Relative  json.Number `json:"relative"`
+			From      string      `json:"from"`
+			To        string      `json:"to"`
+			Keyword   string      `json:"keyword"`
+			StreamID  string      `json:"streamId"`
+			Fields    string      `json:"fields"`
+		} `json:"query"`
+	} `json:"searches"`
+}
+
+// Fetch the saved searches of the Graylog user. Uses the views API (Graylog 3.2 and later), falling back to the legacy
+// saved searches of older versions.
+func fetchServerSearches(opts *options) ([]serverSearch, error) {
+	searches, err := fetchViewSearches(opts)
+	if err == nil {
+		return searches, nil
+	}
+	legacy, legacyErr := fetchLegacySearches(opts)
+	if legacyErr != nil {
+		return nil, err
+	}
+	return legacy, nil
+}
+
+// Fetch the saved searches from the views API. Each saved search refers to a search holding its query, time range and
+// stream filter.
+func fetchViewSearches(opts *options) (searches []serverSearch, err error) {
+	for page := 1; ; page++ {
+		body, err := tryCallGraylog(opts, fmt.Sprintf(viewsInfo, viewsPageSize, page))
+		if err != nil {
+			return nil, err
+		}
+		var views graylogViews
+		if err := json.Unmarshal(body, &views); err != nil {
+			return nil, err
+		}
+		for _, view := range views.Views {
+			if view.Type != "SEARCH" {
+				continue
+			}
+			search := serverSearch{title: view.Title}
+			body, err := tryCallGraylog(opts, fmt.Sprintf(viewSearchInfo, view.SearchID))
+			if err != nil {
+				return nil, err
+			}
+			var details graylogViewSearch
+			if err := json.Unmarshal(body, &details); err != nil {
+				return nil, err
+			}
+			if len(details.Queries) > 0 {
+				q := details.Queries[0]
+				search.query = q.Query.QueryString
+				search.applyTimeRange(q.Timerange)
+				search.streamIds = q.Filter.streamIds()
+			}
+			searches = append(searches, search)
+		}
+		if len(views.Views) < viewsPageSize {
+			return searches, nil
+		}
+	}
+}
+
+// Fetch the saved searches of Graylog versions before the views API.
+func fetchLegacySearches(opts *options) (searches []serverSearch, err error) {
+	body, err := tryCallGraylog(opts, legacySavedSearches)
+	if err != nil {
+		return nil, err
+	}
+	var saved graylogSavedSearches
+	if err := json.Unmarshal(body, &saved); err != nil {
+		return nil, err
+	}
+	for _, s := range saved.Searches {
+		search := serverSearch{title: s.Title, query: s.Query.Query, fields: s.Query.Fields}
+		search.applyTimeRange(graylogTimeRange{
+			Type:    s.Query.RangeType,
+			Range:   s.Query.Relative,
+			From:    json.RawMessage(strconv.Quote(s.Query.From)),
+			To:      json.RawMessage(strconv.Quote(s.Query.To)),
+			Keyword: s.Query.Keyword,
+		})
+		if len(s.Query.StreamID) > 0 {
+			search.streamIds = []string{s.Query.StreamID}
+		}
+		searches = append(searches, search)
+	}
+	return searches, nil
+}
+
+// Translate a Graylog time range: a relative range in seconds, absolute times or a keyword such as 'last week'.
+func (s *serverSearch) applyTimeRange(r graylogTimeRange) {
+	switch r.Type {
+	case "relative":
+		if seconds, err := r.Range.Int64(); err == nil && seconds > 0 {
+			s.rangeText = strconv.FormatInt(seconds, 10) + "s"
+		}
+	case "absolute":
+		_ = json.Unmarshal(r.From, &s.from)
+		_ = json.Unmarshal(r.To, &s.to)
+	case "keyword":
+		s.keyword = r.Keyword
+	}
+}
+
+// Collect the stream ids of a views filter, e.g., {"type":"or","filters":[{"type":"stream","id":"..."}]}.
+func (f *graylogFilter) streamIds() (ids []string) {
+	if f == nil {
+		return nil
+	}
+	if f.Type == "stream" && len(f.ID) > 0 {
+		ids = append(ids, f.ID)
+	}
+	for _, nested := range f.Filters {
+		ids = append(ids, nested.streamIds()...)
+	}
+	return ids
+}
+
+// Find a Graylog saved search by its title. An exact match wins over a case-insensitive one.
+func findServerSearch(searches []serverSearch, title string) (serverSearch, bool) {
+	for _, s := range searches {
+		if s.title == title {
+			return s, true
+		}
+	}
+	for _, s := range searches {
+		if strings.EqualFold(s.title, title) {
+			return s, true
+		}
+	}
+	return serverSearch{}, false
+}
+
+// Print the Graylog saved searches, below the ones from the config file.
+func printServerSearches(searches []serverSearch, streams map[string]map[string]string) {
+	sort.Slice(searches, func(i, j int) bool {
+		return strings.ToLower(searches[i].title) < strings.ToLower(searches[j].title)
+	})
+	for _, s := range searches {
+		printBoldText(s.title + " (Graylog)")
+		var streamNames []string
+		for _, id := range s.streamIds {
+			if title := streams[id]["title"]; len(title) > 0 {
+				streamNames = append(streamNames, title)
+			} else {
+				streamNames = append(streamNames, id)
+			}
+		}
+		timeRange := s.rangeText
+		if len(s.from) > 0 {
+			timeRange = s.from + " to " + s.to
+		} else if len(s.keyword) > 0 {
+			timeRange = s.keyword
+		}
+		printSearchSettings([]searchSetting{
+			{"query", s.query},
+			{"streams", strings.Join(streamNames, ",")},
+			{"range", timeRange},
+			{"columns", s.fields},
+		})
+	}
+}
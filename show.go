@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/buger/jsonparser"
+)
+
+// A stream of a message, resolved to its title.
+type shownStream struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// A field of a message with the type of its value.
+type shownField struct {
+	name  string
+	kind  string
+	value string
+}
+
+// Print a single message with all its details: the index, the fields with their types, the streams and the untouched
+// message text. With --json, the message is printed as Graylog returned it, along with its index and streams.
+func commandShow(opts *options) {
+	index, body := fetchRawMessage(opts, opts.commandArgs[0])
+	raw, _, _, err := jsonparser.Get(body, "message")
+	messages := parseMessages([]byte(`{"messages":[` + string(body) + `]}`))
+	if err != nil || len(messages) == 0 {
+		fmt.Fprintf(os.Stderr, "Unable to read the message '%s' of the index '%s'\n", opts.commandArgs[0], index)
+		os.Exit(1)
+	}
+	msg := messages[0]
+	streams := resolveStreams(msg.streams, fetchStreams(opts))
+
+	var output bytes.Buffer
+	if opts.json {
+		shown := struct {
+			Index   string          `json:"index"`
+			Streams []shownStream   `json:"streams"`
+			Message json.RawMessage `json:"message"`
+		}{index, streams, raw}
+		buf, _ := json.MarshalIndent(shown, "", "  ")
+		output.Write(buf)
+		output.WriteString("\n")
+	} else {
+		printMessageDetails(&output, opts, index, streams, msg)
+	}
+	writePaged(&output, opts.pager)
+}
+
+// Look up the titles of stream ids. Unknown streams keep their id as title.
+func resolveStreams(ids []string, streamLookup map[string]map[string]string) []shownStream {
+	streams := make([]shownStream, 0, len(ids))
+	for _, id := range ids {
+		title := streamLookup[id]["title"]
+		if len(title) == 0 {
+			title = id
+		}
+		streams = append(streams, shownStream{ID: id, Title: title})
+	}
+	return streams
+}
+
+// List the fields of a message, sorted by name, with the type of each value.
+func messageFields(msg logMessage) []shownField {
+	fields := make([]shownField, 0, len(msg.values))
+	for name, value := range msg.values {
+		field := shownField{name: name, value: msg.fields[name]}
+		switch v := value.(type) {
+		case nil:
+			field.kind = "null"
+		case string:
+			field.kind = "string"
+		case json.Number:
+			field.kind = "long"
+			if strings.ContainsAny(v.String(), ".eE") {
+				field.kind = "double"
+			}
+		case bool:
+			field.kind = "boolean"
+		case []interface{}:
+			field.kind = "array"
+		default:
+			field.kind = "object"
+		}
+		fields = append(fields, field)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].name < fields[j].name
+	})
+	return fields
+}
+
+// Print the details of a message: a header, the fields table and the untouched message text.
+func printMessageDetails(w *bytes.Buffer, opts *options, index string, streams []shownStream, msg logMessage) {
+	heading := func(text string) {
+		if opts.useColors {
+			text = boldEsc + text + resetEsc
+		}
+		fmt.Fprintln(w, text)
+	}
+
+	heading("Message " + msg.id)
+	fmt.Fprintf(w, "  %-10s %s\n", "index:", index)
+	fmt.Fprintf(w, "  %-10s %s\n", "timestamp:", longTime(msg.timestamp, opts.location, opts.timeFormat))
+	var titles []string
+	for _, s := range streams {
+		if s.Title == s.ID {
+			titles = append(titles, s.ID)
+		} else {
+			titles = append(titles, s.Title+" ("+s.ID+")")
+		}
+	}
+	if len(titles) > 0 {
+		fmt.Fprintf(w, "  %-10s %s\n", "streams:", strings.Join(titles, ", "))
+	}
+
+	fields := messageFields(msg)
+	nameWidth, kindWidth := 0, 0
+	for _, f := range fields {
+		if len(f.name) > nameWidth {
+			nameWidth = len(f.name)
+		}
+		if len(f.kind) > kindWidth {
+			kindWidth = len(f.kind)
+		}
+	}
+	fmt.Fprintln(w)
+	heading("Fields")
+	for _, f := range fields {
+		// Align the continuation lines of multi-line values with the first line
+		value := strings.Replace(f.value, "\n", "\n"+strings.Repeat(" ", nameWidth+kindWidth+6), -1)
+		fmt.Fprintf(w, "  %-*s  %-*s  %s\n", nameWidth, f.name, kindWidth, f.kind, value)
+	}
+
+	text := msg.fields[fullMessageField]
+	if len(text) == 0 {
+		text = msg.fields[messageField]
+	}
+	if len(text) > 0 {
+		fmt.Fprintln(w)
+		heading("Full message")
+		fmt.Fprintln(w, strings.TrimRight(text, "\n"))
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Java and .NET frames: "	at com.example.Foo.bar(Foo.java:42)", "   at Example.Foo.Bar() in C:\Foo.cs:line 42"
+var atFrameRegex = regexp.MustCompile(`^\s+at\s+([^\s(]+)\(.*\)`)
+
+// Java frame summaries: "	... 42 more", "	... 42 common frames omitted"
+var moreFramesRegex = regexp.MustCompile(`^\s+\.\.\. \d+ (more|common frames omitted)\s*$`)
+
+// Python frames: '  File "/app/example/foo.py", line 42, in bar', followed by the indented source line.
+var pythonFrameRegex = regexp.MustCompile(`^\s+File "([^"]+)", line \d+`)
+
+// Go panic frames: "example.com/foo.(*Bar).Baz(0x1, 0x2)" or "created by example.com/foo.Start", followed by
+// "	/go/src/example.com/foo/bar.go:42 +0x1d".
+var goFunctionRegex = regexp.MustCompile(`^(?:created by )?([\w./*()\-]+\.[\w.*()\-]+?)(?:\(.*\))?(?: in goroutine \d+)?$`)
+var goFileRegex = regexp.MustCompile(`^\t.+\.go:\d+`)
+
+// traceFolding holds the settings used to collapse stacktraces.
+type traceFolding struct {
+	frames   int
+	packages []string
+}
+
+// A single stacktrace frame, or a line of text that isn't part of a frame.
+type traceLine struct {
+	lines    []string
+	frame    bool
+	location string
+	python   bool
+}
+
+// Collapse the stacktraces in the text to the exception lines plus the first application frames of each trace.
+// Python traces list the innermost frame last, so the last application frames are kept for them.
+func foldStacktraces(text string, folding *traceFolding) string {
+	if folding == nil || !strings.Contains(text, "\n") {
+		return text
+	}
+
+	parsed := parseTraceLines(strings.Split(text, "\n"))
+
+	var result []string
+	for i := 0; i < len(parsed); {
+		if !parsed[i].frame {
+			result = append(result, parsed[i].lines...)
+			i++
+			continue
+		}
+		j := i
+		for j < len(parsed) && parsed[j].frame {
+			j++
+		}
+		result = append(result, folding.foldFrames(parsed[i:j])...)
+		i = j
+	}
+	return strings.Join(result, "\n")
+}
+
+// Split the lines of a message into frames and plain text.
+func parseTraceLines(lines []string) (parsed []traceLine) {
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if m := atFrameRegex.FindStringSubmatch(line); m != nil {
+			parsed = append(parsed, traceLine{lines: []string{line}, frame: true, location: m[1]})
+		} else if moreFramesRegex.MatchString(line) {
+			parsed = append(parsed, traceLine{lines: []string{line}, frame: true})
+		} else if m := pythonFrameRegex.FindStringSubmatch(line); m != nil {
+			frame := traceLine{lines: []string{line}, frame: true, location: m[1], python: true}
+			if i+1 < len(lines) && isPythonSourceLine(line, lines[i+1]) {
+				frame.lines = append(frame.lines, lines[i+1])
+				i++
+			}
+			parsed = append(parsed, frame)
+		} else if m := goFunctionRegex.FindStringSubmatch(line); m != nil && i+1 < len(lines) && goFileRegex.MatchString(lines[i+1]) {
+			parsed = append(parsed, traceLine{lines: []string{line, lines[i+1]}, frame: true, location: m[1]})
+			i++
+		} else {
+			parsed = append(parsed, traceLine{lines: []string{line}})
+		}
+	}
+	return parsed
+}
+
+// Python prints the source line of each frame indented further than the frame itself.
+func isPythonSourceLine(frameLine string, nextLine string) bool {
+	if pythonFrameRegex.MatchString(nextLine) {
+		return false
+	}
+	indent := len(frameLine) - len(strings.TrimLeft(frameLine, " \t"))
+	nextIndent := len(nextLine) - len(strings.TrimLeft(nextLine, " \t"))
+	return nextIndent > indent && len(strings.TrimSpace(nextLine)) > 0
+}
+
+// Keep the first application frames of a run of frames and replace the rest with a summary line.
+func (f *traceFolding) foldFrames(frames []traceLine) []string {
+	python := frames[0].python
+
+	keep := make([]bool, len(frames))
+	var kept int
+	for n := range frames {
+		i := n
+		if python {
+			i = len(frames) - 1 - n
+		}
+		if kept < f.frames && f.isApplicationFrame(frames[i]) {
+			keep[i] = true
+			kept++
+		}
+	}
+
+	var result []string
+	var folded int
+	indent := "\t"
+	for i, frame := range frames {
+		if keep[i] {
+			result = append(result, frame.lines...)
+			continue
+		}
+		if folded == 0 {
+			indent = frame.lines[0][:len(frame.lines[0])-len(strings.TrimLeft(frame.lines[0], " \t"))]
+		}
+		folded++
+	}
+	if folded > 0 {
+		summary := fmt.Sprintf("%s... %d frames folded", indent, folded)
+		if python {
+			return append([]string{summary}, result...)
+		}
+		result = append(result, summary)
+	}
+	return result
+}
+
+// Check whether a frame belongs to the application. When no application packages are configured, every frame does.
+func (f *traceFolding) isApplicationFrame(frame traceLine) bool {
+	if len(frame.location) == 0 {
+		return false
+	}
+	if len(f.packages) == 0 {
+		return true
+	}
+	for _, pkg := range f.packages {
+		if strings.HasPrefix(frame.location, pkg) || strings.Contains(frame.location, "/"+pkg) {
+			return true
+		}
+	}
+	return false
+}
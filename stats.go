@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rolling windows of the --stats panel. The rate is shown over the short and medium windows, the levels and sources
+// are counted over the long one.
+const statsShortWindow = 10 * time.Second
+const statsMediumWindow = time.Minute
+const statsLongWindow = 5 * time.Minute
+
+// Number of sources listed by the --stats panel.
+const statsTopSources = 5
+
+// How often the --stats panel is redrawn. When stderr isn't a terminal, the panel is printed once per medium window.
+const statsRefresh = time.Second
+
+// A message counted by the --stats panel.
+type statEvent struct {
+	at     time.Time
+	level  string
+	source string
+}
+
+// A value and the number of messages that have it.
+type statCount struct {
+	name  string
+	count int
+}
+
+// tailStats keeps the messages seen while tailing over rolling windows.
+type tailStats struct {
+	started time.Time
+	total   int
+	events  []statEvent
+}
+
+// Count messages. They're placed in the windows by their timestamp, so the messages of the first search of the tail
+// count for when they were logged, not all at once. The messages must already be adjusted.
+func (s *tailStats) add(now time.Time, messages []logMessage) {
+	for _, msg := range messages {
+		level := msg.fields[logLevelField]
+		if name, ok := syslogLevels[level]; ok {
+			level = name
+		}
+		at := msg.timestamp
+		if at.After(now) {
+			at = now
+		}
+		s.events = append(s.events, statEvent{at: at, level: level, source: msg.fields[sourceField]})
+	}
+	sort.SliceStable(s.events, func(i, j int) bool {
+		return s.events[i].at.Before(s.events[j].at)
+	})
+	s.total += len(messages)
+	s.prune(now)
+}
+
+// Forget the messages older than the long window.
+func (s *tailStats) prune(now time.Time) {
+	i := sort.Search(len(s.events), func(i int) bool {
+		return now.Sub(s.events[i].at) < statsLongWindow
+	})
+	s.events = s.events[i:]
+}
+
+// Messages per second over a window.
+func (s *tailStats) rate(now time.Time, window time.Duration) float64 {
+	count := 0
+	for i := len(s.events) - 1; i >= 0 && now.Sub(s.events[i].at) < window; i-- {
+		count++
+	}
+	return float64(count) / window.Seconds()
+}
+
+// Count the messages of the long window by a property, most frequent first.
+func (s *tailStats) countBy(value func(statEvent) string) []statCount {
+	counts := make(map[string]int)
+	for _, e := range s.events {
+		if v := value(e); len(v) > 0 {
+			counts[v]++
+		}
+	}
+	result := make([]statCount, 0, len(counts))
+	for name, count := range counts {
+		result = append(result, statCount{name, count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].count != result[j].count {
+			return result[i].count > result[j].count
+		}
+		return result[i].name < result[j].name
+	})
+	return result
+}
+
+// Render the panel: the rates, the counts by level and the top sources.
+func (s *tailStats) render(now time.Time, colors *colorScheme, useColors bool) []string {
+	elapsed := now.Sub(s.started).Truncate(time.Second)
+	short, medium := rangeLabel(int(statsShortWindow.Seconds())), rangeLabel(int(statsMediumWindow.Seconds()))
+	lines := []string{
+		fmt.Sprintf("── %s ── %d messages in %s", now.Format(timeFormat), s.total, elapsed),
+		fmt.Sprintf("rate     %.1f/s (%s)  %.1f/s (%s)",
+			s.rate(now, statsShortWindow), short, s.rate(now, statsMediumWindow), medium),
+	}
+
+	var levels []string
+	for _, c := range s.countBy(func(e statEvent) string { return e.level }) {
+		text := fmt.Sprintf("%s %d", c.name, c.count)
+		if useColors {
+			if esc := colors.levelColor(c.name); len(esc) > 0 {
+				text = esc + text + resetEsc
+			}
+		}
+		levels = append(levels, text)
+	}
+	lines = append(lines, fmt.Sprintf("levels   %s", strings.Join(levels, "  ")))
+
+	var sources []string
+	for i, c := range s.countBy(func(e statEvent) string { return e.source }) {
+		if i == statsTopSources {
+			break
+		}
+		sources = append(sources, fmt.Sprintf("%s %d", c.name, c.count))
+	}
+	lines = append(lines, fmt.Sprintf("sources  %s", strings.Join(sources, "  ")))
+	if useColors {
+		lines[0] = boldEsc + lines[0] + resetEsc
+	}
+	return lines
+}
+
+// statsPanel draws the --stats panel on stderr below the tailed messages, redrawing it in place on a terminal.
+type statsPanel struct {
+	mu        sync.Mutex
+	w         io.Writer
+	stats     tailStats
+	terminal  bool
+	colors    *colorScheme
+	useColors bool
+	drawn     int
+	printed   time.Time
+}
+
+func newStatsPanel(opts *options) *statsPanel {
+	terminal := isTerminal(os.Stderr)
+	return &statsPanel{
+		w:         os.Stderr,
+		stats:     tailStats{started: time.Now()},
+		terminal:  terminal,
+		colors:    opts.colors,
+		useColors: terminal && opts.useColors,
+	}
+}
+
+// Redraw the panel every second, so the rates keep moving while no messages arrive.
+func (p *statsPanel) start() {
+	go func() {
+		for range time.Tick(statsRefresh) {
+			p.mu.Lock()
+			p.redraw()
+			p.mu.Unlock()
+		}
+	}()
+}
+
+// Count new messages and print them above the panel, unless only the panel is shown.
+func (p *statsPanel) add(opts *options, streams map[string]map[string]string, messages []logMessage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	if opts.statsOnly {
+		for _, msg := range messages {
+			adjustMessage(opts, streams, msg)
+		}
+	} else {
+		printMessages(os.Stdout, messages, opts, streams)
+	}
+	p.stats.add(time.Now(), filterWhere(opts.where, messages))
+	p.redraw()
+}
+
+// Erase the panel from the terminal.
+func (p *statsPanel) clear() {
+	if p.terminal && p.drawn > 0 {
+		fmt.Fprintf(p.w, "\r\033[%dA\033[J", p.drawn)
+		p.drawn = 0
+	}
+}
+
+func (p *statsPanel) redraw() {
+	now := time.Now()
+	p.stats.prune(now)
+	if !p.terminal {
+		if now.Sub(p.printed) < statsMediumWindow {
+			return
+		}
+		p.printed = now
+	}
+	p.clear()
+	text := strings.Join(p.stats.render(now, p.colors, p.useColors), "\n") + "\n"
+	fmt.Fprint(p.w, text)
+	// Long lines wrap, the panel is erased by moving up as many screen lines
+	_, cols, _ := terminalSize(os.Stderr)
+	p.drawn = screenLines(text, cols)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araddon/dateparse"
+)
+
+// Approximate lengths of the calendar units used in ranges.
+const day = 24 * time.Hour
+const week = 7 * day
+const month = 30 * day
+const year = 365 * day
+
+// Units accepted in time ranges, e.g., 90s, 15m, 2h, 3d, 1w, 6mo or 1y, and their long forms (2 hours, 3 days, ...).
+var durationUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": day, "day": day, "days": day,
+	"w": week, "wk": week, "wks": week, "week": week, "weeks": week,
+	"mo": month, "mon": month, "month": month, "months": month,
+	"y": year, "yr": year, "yrs": year, "year": year, "years": year,
+}
+
+// A number followed by a unit, e.g., "15m" or "2 hours".
+var durationPartRegex = regexp.MustCompile(`^\s*(\d+(?:\.\d+)?)\s*([a-zA-Z]+)`)
+
+// ISO-8601 durations, e.g., PT30M, P1DT12H or P2W.
+var isoDurationRegex = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// Clock times, e.g., 14:00, 2:30:15pm or 9am. Bare numbers are checked for by isClockTime.
+var clockRegex = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(?::(\d{2}))?\s*(am|pm)?$`)
+
+// "now", optionally with an offset, e.g., now-15m or now + 1h.
+var nowRegex = regexp.MustCompile(`^now\s*(?:([+-])\s*(.+))?$`)
+
+// Parse a time range such as "2h", "3d2h30m", "1w", "6mo", "2 hours" or an ISO-8601 duration such as "PT30M".
+func parseDuration(text string) (time.Duration, error) {
+	text = strings.TrimSpace(text)
+	if len(text) == 0 {
+		return 0, fmt.Errorf("empty time range")
+	}
+
+	if m := isoDurationRegex.FindStringSubmatch(strings.ToUpper(text)); m != nil {
+		units := []time.Duration{year, month, week, day, time.Hour, time.Minute, time.Second}
+		var total time.Duration
+		for i, unit := range units {
+			if len(m[i+1]) > 0 {
+				n, _ := strconv.ParseFloat(m[i+1], 64)
+				total += time.Duration(n * float64(unit))
+			}
+		}
+		if total <= 0 {
+			return 0, fmt.Errorf("'%s' is an empty duration", text)
+		}
+		return total, nil
+	}
+
+	var total time.Duration
+	rest := text
+	for len(strings.TrimSpace(rest)) > 0 {
+		m := durationPartRegex.FindStringSubmatch(rest)
+		if m == nil {
+			return 0, fmt.Errorf("'%s' isn't a time range, use e.g. 30m, 2h, 3d, 1w, 6mo or PT30M", text)
+		}
+		unit, ok := durationUnits[strings.ToLower(m[2])]
+		if !ok {
+			return 0, fmt.Errorf("unknown unit '%s' in '%s', use s, m, h, d, w, mo or y", m[2], text)
+		}
+		n, _ := strconv.ParseFloat(m[1], 64)
+		total += time.Duration(n * float64(unit))
+		rest = rest[len(m[0]):]
+	}
+	if total <= 0 {
+		return 0, fmt.Errorf("'%s' is an empty time range", text)
+	}
+	return total, nil
+}
+
+// Parse a point in time. Besides absolute dates and clock times (handled by dateparse), understands:
+//
+//	now, now-15m, now+1h           the current time, optionally shifted by a time range
+//	15m ago, 2 hours ago           the current time, shifted back
+//	today, yesterday, tomorrow     midnight of the day, or a time of that day, e.g., "yesterday 14:00"
+//	monday, last friday 9am        the most recent such day (today included, unless 'last' is given)
+//	14:00, 2:30pm                  a time today
+func parseTimeExpression(text string, now time.Time, loc *time.Location) (time.Time, error) {
+	now = now.In(loc)
+	expr := strings.ToLower(strings.Join(strings.Fields(text), " "))
+
+	if m := nowRegex.FindStringSubmatch(expr); m != nil {
+		if len(m[1]) == 0 {
+			return now, nil
+		}
+		d, err := parseDuration(m[2])
+		if err != nil {
+			return time.Time{}, err
+		}
+		if m[1] == "-" {
+			d = -d
+		}
+		return now.Add(d), nil
+	}
+
+	if strings.HasSuffix(expr, " ago") {
+		d, err := parseDuration(strings.TrimSuffix(expr, " ago"))
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(-d), nil
+	}
+
+	words := strings.SplitN(expr, " ", 3)
+	var dayStart *time.Time
+	var clock string
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	switch words[0] {
+	case "today":
+		dayStart = &midnight
+		clock = strings.Join(words[1:], " ")
+	case "yesterday":
+		d := midnight.AddDate(0, 0, -1)
+		dayStart = &d
+		clock = strings.Join(words[1:], " ")
+	case "tomorrow":
+		d := midnight.AddDate(0, 0, 1)
+		dayStart = &d
+		clock = strings.Join(words[1:], " ")
+	default:
+		last := words[0] == "last" && len(words) > 1
+		name := words[0]
+		clock = strings.Join(words[1:], " ")
+		if last {
+			name = words[1]
+			clock = strings.Join(words[2:], " ")
+		}
+		// Dates such as "wed jun 5 2019" are left to dateparse
+		if weekday, ok := parseWeekday(name); ok && (len(clock) == 0 || isClockTime(clock)) {
+			days := (int(now.Weekday()) - int(weekday) + 7) % 7
+			if last && days == 0 {
+				days = 7
+			}
+			d := midnight.AddDate(0, 0, -days)
+			dayStart = &d
+		}
+	}
+	if dayStart != nil {
+		if len(clock) == 0 {
+			return *dayStart, nil
+		}
+		return atClock(*dayStart, clock)
+	}
+
+	if isClockTime(expr) {
+		return atClock(midnight, expr)
+	}
+
+	t, err := dateparse.ParseIn(text, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if t.Year() == 0 {
+		t = t.AddDate(now.Year(), 0, 0)
+	}
+	return t, nil
+}
+
+// Match a day of the week by its name or abbreviation, e.g., "monday" or "mon".
+func parseWeekday(name string) (time.Weekday, bool) {
+	if len(name) < 3 {
+		return 0, false
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.HasPrefix(strings.ToLower(d.String()), name) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// Check whether the text is a time of day. Bare numbers, e.g., "14", aren't.
+func isClockTime(text string) bool {
+	m := clockRegex.FindStringSubmatch(text)
+	return m != nil && (len(m[2]) > 0 || len(m[4]) > 0)
+}
+
+// Set the clock time, e.g., "14:00" or "2pm", of a day.
+func atClock(dayStart time.Time, clock string) (time.Time, error) {
+	m := clockRegex.FindStringSubmatch(strings.TrimSpace(clock))
+	if m == nil || !isClockTime(strings.TrimSpace(clock)) {
+		return time.Time{}, fmt.Errorf("'%s' isn't a time of day", clock)
+	}
+	hour, _ := strconv.Atoi(m[1])
+	minute, _ := strconv.Atoi("0" + m[2])
+	second, _ := strconv.Atoi("0" + m[3])
+	if len(m[4]) > 0 {
+		if hour < 1 || hour > 12 {
+			return time.Time{}, fmt.Errorf("'%s' isn't a time of day", clock)
+		}
+		hour %= 12
+		if m[4] == "pm" {
+			hour += 12
+		}
+	}
+	if hour > 23 || minute > 59 || second > 59 {
+		return time.Time{}, fmt.Errorf("'%s' isn't a time of day", clock)
+	}
+	return time.Date(dayStart.Year(), dayStart.Month(), dayStart.Day(), hour, minute, second, 0, dayStart.Location()), nil
+}
@@ -0,0 +1,9 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// The ioctl request used to read the terminal attributes of a file descriptor.
+const ioctlReadTermios = unix.TIOCGETA
@@ -0,0 +1,6 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// The ioctl request used to read the terminal attributes of a file descriptor.
+const ioctlReadTermios = unix.TCGETS
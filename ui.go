@@ -0,0 +1,814 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+)
+
+// Most messages kept in the list of the ui command. While tailing, the oldest ones are dropped.
+const uiMaxMessages = 10000
+
+// Time range shortcuts of the ui command.
+var uiRanges = []struct {
+	key  rune
+	text string
+}{
+	{'1', "5m"}, {'2', "15m"}, {'3', "1h"}, {'4', "4h"}, {'5', "1d"}, {'6', "1w"},
+}
+
+// What the keys of the ui currently do: browse the messages, edit the query, filter the loaded messages or pick
+// streams.
+const (
+	uiBrowse = iota
+	uiQuery
+	uiFilter
+	uiStreams
+)
+
+const uiHelp = "q quit  ↑↓ move  tab fields  / filter  e query  s streams  t tail  r refresh  " +
+	"1-6 range (5m 15m 1h 4h 1d 1w)"
+
+// A loaded message and its row in the list, rendered by the format templates.
+type uiEntry struct {
+	msg  logMessage
+	text string
+}
+
+// Sent by the goroutine fetching messages when Graylog answers.
+type uiFetched struct {
+	generation int
+	tail       bool
+	messages   []logMessage
+	err        error
+}
+
+// Sent when it's time to look for new messages while tailing.
+type uiPoll struct {
+	generation int
+	tailRun    int
+}
+
+// A run of text drawn with the same style.
+type styledText struct {
+	text  string
+	style tcell.Style
+}
+
+// browser holds the state of the ui command.
+type browser struct {
+	opts       *options
+	screen     tcell.Screen
+	streams    map[string]map[string]string
+	rangeLabel string
+
+	entries     []uiEntry
+	seen        map[string]bool
+	visible     []int
+	selected    int
+	selectedID  string
+	top         int
+	detailTop   int
+	focusFields bool
+	filter      string
+
+	mode   int
+	input  []rune
+	cursor int
+	status string
+
+	streamList   []map[string]string
+	streamChoice map[string]bool
+	streamCursor int
+	streamTop    int
+
+	tailing    bool
+	tailRun    int
+	delay      float64
+	loading    bool
+	generation int
+	quit       bool
+}
+
+// Browse and tail messages in a full-screen terminal ui.
+func commandUI(opts *options) {
+	uiOpts := *opts
+	// Messages are searched, never exported, by the ui
+	uiOpts.fields = ""
+	streams := fetchStreams(&uiOpts)
+
+	screen, err := tcell.NewScreen()
+	if err == nil {
+		err = screen.Init()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to start the terminal ui: %s\n", err.Error())
+		os.Exit(1)
+	}
+	defer screen.Fini()
+
+	b := newBrowser(&uiOpts, screen, streams)
+	b.search()
+	if opts.tail {
+		b.toggleTail()
+	}
+	for !b.quit {
+		b.draw()
+		switch ev := screen.PollEvent().(type) {
+		case *tcell.EventResize:
+			screen.Sync()
+		case *tcell.EventKey:
+			b.handleKey(ev)
+		case *tcell.EventInterrupt:
+			switch data := ev.Data().(type) {
+			case uiFetched:
+				b.receive(data)
+			case uiPoll:
+				if data.generation == b.generation && data.tailRun == b.tailRun && b.tailing && !b.loading {
+					b.fetch(true)
+				}
+			}
+		}
+	}
+}
+
+func newBrowser(opts *options, screen tcell.Screen, streams map[string]map[string]string) *browser {
+	b := &browser{
+		opts:         opts,
+		screen:       screen,
+		streams:      streams,
+		seen:         make(map[string]bool),
+		streamChoice: make(map[string]bool),
+		delay:        minDelay,
+	}
+	if opts.startDate != nil {
+		b.rangeLabel = longTime(*opts.startDate, opts.location, graylogInputTimeFormat) + " to " +
+			longTime(*opts.endDate, opts.location, graylogInputTimeFormat)
+	} else {
+		b.rangeLabel = rangeLabel(opts.timeRange)
+	}
+	for _, s := range streams {
+		b.streamList = append(b.streamList, s)
+	}
+	sort.Slice(b.streamList, func(i, j int) bool {
+		return strings.ToLower(b.streamList[i]["title"]) < strings.ToLower(b.streamList[j]["title"])
+	})
+	return b
+}
+
+// Describe a relative range in seconds with its largest unit, e.g., 3600 is "1h".
+func rangeLabel(seconds int) string {
+	for _, unit := range []struct {
+		suffix string
+		size   int
+	}{{"w", 604800}, {"d", 86400}, {"h", 3600}, {"m", 60}} {
+		if seconds >= unit.size && seconds%unit.size == 0 {
+			return strconv.Itoa(seconds/unit.size) + unit.suffix
+		}
+	}
+	return strconv.Itoa(seconds) + "s"
+}
+
+// Start a new search, replacing the loaded messages once Graylog answers.
+func (b *browser) search() {
+	b.generation++
+	b.fetch(false)
+}
+
+// Fetch messages in the background. The result is handled by receive.
+func (b *browser) fetch(tail bool) {
+	b.loading = true
+	opts := *b.opts
+	generation := b.generation
+	go func() {
+		messages, err := fetchUIMessages(&opts)
+		_ = b.screen.PostEvent(tcell.NewEventInterrupt(uiFetched{generation, tail, messages, err}))
+	}()
+}
+
+// Fetch the messages of a search, returning an error instead of exiting when Graylog can't be reached.
+func fetchUIMessages(opts *options) ([]logMessage, error) {
+	if len(opts.archivePath) > 0 {
+		return fetchArchivedMessages(opts), nil
+	}
+	api, _ := messageAPIURI(opts)
+	body, err := tryCallGraylog(opts, api)
+	if err != nil {
+		return nil, err
+	}
+	return parseMessages(body), nil
+}
+
+// Add fetched messages to the list. A new search replaces the list, tailing appends the messages not seen yet.
+func (b *browser) receive(fetched uiFetched) {
+	if fetched.generation != b.generation {
+		return
+	}
+	b.loading = false
+	if fetched.err != nil {
+		b.status = fetched.err.Error()
+	} else {
+		added := b.addMessages(fetched.messages, !fetched.tail)
+		if fetched.tail {
+			b.delay = adjustDelay(b.delay, added)
+		}
+	}
+	if b.tailing {
+		b.schedulePoll()
+	}
+}
+
+// Add messages to the list, keeping the selection on the same message unless it was on the last one. Returns the
+// messages that weren't loaded yet.
+func (b *browser) addMessages(messages []logMessage, replace bool) (added []logMessage) {
+	follow := replace || b.selected >= len(b.visible)-1
+	if replace {
+		b.entries = nil
+		b.seen = make(map[string]bool)
+		b.top = 0
+	}
+	for _, msg := range messages {
+		if b.seen[msg.id] {
+			continue
+		}
+		b.seen[msg.id] = true
+		added = append(added, msg)
+		var row bytes.Buffer
+		printMessage(&row, b.opts, b.streams, msg)
+		if row.Len() == 0 {
+			// Excluded by --where
+			continue
+		}
+		text := strings.SplitN(row.String(), "\n", 2)[0]
+		b.entries = append(b.entries, uiEntry{msg: msg, text: text})
+	}
+	if len(b.entries) > uiMaxMessages {
+		b.entries = b.entries[len(b.entries)-uiMaxMessages:]
+	}
+	b.applyFilter(follow)
+	return added
+}
+
+// Wait before looking for new messages, backing off while none arrive.
+func (b *browser) schedulePoll() {
+	poll := uiPoll{b.generation, b.tailRun}
+	time.AfterFunc(time.Duration(b.delay*1000)*time.Millisecond, func() {
+		_ = b.screen.PostEvent(tcell.NewEventInterrupt(poll))
+	})
+}
+
+func (b *browser) toggleTail() {
+	if !b.tailing && b.opts.startDate != nil {
+		b.status = "Live tail needs a relative range, press 1-6 to pick one"
+		return
+	}
+	b.tailing = !b.tailing
+	b.tailRun++
+	b.delay = minDelay
+	if b.tailing && !b.loading {
+		b.fetch(true)
+	}
+}
+
+// Compute the messages shown by the list: the loaded messages that contain the / filter text, ignoring case, in
+// their row or in one of their fields.
+func (b *browser) applyFilter(follow bool) {
+	needle := strings.ToLower(b.filter)
+	b.visible = b.visible[:0]
+	for i, e := range b.entries {
+		if len(needle) == 0 || entryContains(e, needle) {
+			b.visible = append(b.visible, i)
+		}
+	}
+
+	b.selected = len(b.visible) - 1
+	if !follow {
+		for i, entry := range b.visible {
+			if b.entries[entry].msg.id == b.selectedID {
+				b.selected = i
+				break
+			}
+		}
+	}
+	b.selectRow(b.selected)
+}
+
+func entryContains(e uiEntry, needle string) bool {
+	if strings.Contains(strings.ToLower(plainText(e.text)), needle) {
+		return true
+	}
+	for _, v := range e.msg.fields {
+		if strings.Contains(strings.ToLower(v), needle) {
+			return true
+		}
+	}
+	return false
+}
+
+// Move the selection to a row of the list, keeping it on screen.
+func (b *browser) selectRow(row int) {
+	if row >= len(b.visible) {
+		row = len(b.visible) - 1
+	}
+	if row < 0 {
+		row = 0
+	}
+	if row != b.selected || len(b.selectedID) == 0 {
+		b.detailTop = 0
+	}
+	b.selected = row
+	b.selectedID = ""
+	if row < len(b.visible) {
+		b.selectedID = b.entries[b.visible[row]].msg.id
+	}
+
+	_, listHeight, _, _ := b.layout()
+	if b.selected < b.top {
+		b.top = b.selected
+	}
+	if b.selected >= b.top+listHeight {
+		b.top = b.selected - listHeight + 1
+	}
+	if b.top < 0 {
+		b.top = 0
+	}
+}
+
+func (b *browser) handleKey(ev *tcell.EventKey) {
+	b.status = ""
+	switch b.mode {
+	case uiQuery, uiFilter:
+		b.handleInputKey(ev)
+	case uiStreams:
+		b.handleStreamKey(ev)
+	default:
+		b.handleBrowseKey(ev)
+	}
+}
+
+func (b *browser) handleBrowseKey(ev *tcell.EventKey) {
+	_, listHeight, _, detailHeight := b.layout()
+	move := func(delta int) {
+		if b.focusFields {
+			b.detailTop += delta
+			if max := len(b.detailLines()) - detailHeight; b.detailTop > max {
+				b.detailTop = max
+			}
+			if b.detailTop < 0 {
+				b.detailTop = 0
+			}
+		} else {
+			b.selectRow(b.selected + delta)
+		}
+	}
+
+	switch ev.Key() {
+	case tcell.KeyCtrlC:
+		b.quit = true
+	case tcell.KeyUp:
+		move(-1)
+	case tcell.KeyDown:
+		move(1)
+	case tcell.KeyPgUp:
+		move(-listHeight)
+	case tcell.KeyPgDn:
+		move(listHeight)
+	case tcell.KeyHome:
+		move(-len(b.entries) - len(b.detailLines()))
+	case tcell.KeyEnd:
+		move(len(b.entries) + len(b.detailLines()))
+	case tcell.KeyTab:
+		b.focusFields = !b.focusFields
+	case tcell.KeyEscape:
+		if len(b.filter) > 0 {
+			b.filter = ""
+			b.applyFilter(false)
+		}
+	case tcell.KeyRune:
+		switch r := ev.Rune(); r {
+		case 'q':
+			b.quit = true
+		case 'k':
+			move(-1)
+		case 'j':
+			move(1)
+		case 'g':
+			move(-len(b.entries) - len(b.detailLines()))
+		case 'G':
+			move(len(b.entries) + len(b.detailLines()))
+		case 't':
+			b.toggleTail()
+		case 'r':
+			b.search()
+		case 'e':
+			b.startInput(uiQuery, b.opts.query)
+		case '/':
+			b.startInput(uiFilter, b.filter)
+		case 's':
+			b.mode = uiStreams
+			b.streamChoice = make(map[string]bool)
+			for _, id := range b.opts.streamIds {
+				b.streamChoice[id] = true
+			}
+		default:
+			for _, shortcut := range uiRanges {
+				if shortcut.key == r {
+					seconds, _ := parseDuration(shortcut.text)
+					b.opts.timeRange = int(seconds.Seconds())
+					b.opts.startDate, b.opts.endDate = nil, nil
+					b.rangeLabel = shortcut.text
+					b.search()
+				}
+			}
+		}
+	}
+}
+
+// Start editing the query or the filter in the bottom line.
+func (b *browser) startInput(mode int, text string) {
+	b.mode = mode
+	b.input = []rune(text)
+	b.cursor = len(b.input)
+}
+
+// Edit the input line. The filter is applied as it's typed, the query is searched for on enter.
+func (b *browser) handleInputKey(ev *tcell.EventKey) {
+	switch ev.Key() {
+	case tcell.KeyEnter:
+		if b.mode == uiQuery {
+			b.opts.query = strings.TrimSpace(string(b.input))
+			b.search()
+		}
+		b.mode = uiBrowse
+		return
+	case tcell.KeyEscape:
+		if b.mode == uiFilter {
+			b.filter = ""
+			b.applyFilter(false)
+		}
+		b.mode = uiBrowse
+		return
+	case tcell.KeyLeft:
+		if b.cursor > 0 {
+			b.cursor--
+		}
+	case tcell.KeyRight:
+		if b.cursor < len(b.input) {
+			b.cursor++
+		}
+	case tcell.KeyHome, tcell.KeyCtrlA:
+		b.cursor = 0
+	case tcell.KeyEnd, tcell.KeyCtrlE:
+		b.cursor = len(b.input)
+	case tcell.KeyCtrlU:
+		b.input = b.input[b.cursor:]
+		b.cursor = 0
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if b.cursor > 0 {
+			b.input = append(b.input[:b.cursor-1], b.input[b.cursor:]...)
+			b.cursor--
+		}
+	case tcell.KeyDelete:
+		if b.cursor < len(b.input) {
+			b.input = append(b.input[:b.cursor], b.input[b.cursor+1:]...)
+		}
+	case tcell.KeyRune:
+		b.input = append(b.input[:b.cursor], append([]rune{ev.Rune()}, b.input[b.cursor:]...)...)
+		b.cursor++
+	}
+	if b.mode == uiFilter {
+		b.filter = string(b.input)
+		b.applyFilter(false)
+	}
+}
+
+// Pick the streams to search: space toggles a stream, enter searches the picked streams, all of them if none are.
+func (b *browser) handleStreamKey(ev *tcell.EventKey) {
+	switch ev.Key() {
+	case tcell.KeyUp:
+		b.streamCursor--
+	case tcell.KeyDown:
+		b.streamCursor++
+	case tcell.KeyEscape:
+		b.mode = uiBrowse
+	case tcell.KeyEnter:
+		b.opts.streamIds = nil
+		for _, s := range b.streamList {
+			if b.streamChoice[s["id"]] {
+				b.opts.streamIds = append(b.opts.streamIds, s["id"])
+			}
+		}
+		b.mode = uiBrowse
+		b.search()
+	case tcell.KeyRune:
+		switch ev.Rune() {
+		case 'k':
+			b.streamCursor--
+		case 'j':
+			b.streamCursor++
+		case ' ':
+			if b.streamCursor < len(b.streamList) {
+				id := b.streamList[b.streamCursor]["id"]
+				b.streamChoice[id] = !b.streamChoice[id]
+			}
+		}
+	}
+	if b.streamCursor >= len(b.streamList) {
+		b.streamCursor = len(b.streamList) - 1
+	}
+	if b.streamCursor < 0 {
+		b.streamCursor = 0
+	}
+}
+
+// Split the screen: a header line, the message list, a divider, the fields of the selected message and a status
+// line.
+func (b *browser) layout() (listTop int, listHeight int, detailTop int, detailHeight int) {
+	_, height := b.screen.Size()
+	body := height - 3
+	if body < 2 {
+		return 1, 1, 3, 0
+	}
+	listHeight = body * 3 / 5
+	if listHeight < 1 {
+		listHeight = 1
+	}
+	return 1, listHeight, listHeight + 2, body - listHeight
+}
+
+// The fields of the selected message, sorted by name, one line each. Multi-line values continue below their first
+// line.
+func (b *browser) detailLines() []string {
+	if b.selected >= len(b.visible) {
+		return nil
+	}
+	msg := b.entries[b.visible[b.selected]].msg
+	var names []string
+	width := 0
+	for name, value := range msg.fields {
+		// Color helper fields have no text of their own
+		if len(plainText(value)) > 0 {
+			names = append(names, name)
+			if len(name) > width {
+				width = len(name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		for i, line := range strings.Split(plainText(msg.fields[name]), "\n") {
+			label := ""
+			if i == 0 {
+				label = name
+			}
+			lines = append(lines, fmt.Sprintf("%-*s  %s", width, label, line))
+		}
+	}
+	return lines
+}
+
+func (b *browser) draw() {
+	s := b.screen
+	s.Clear()
+	width, height := s.Size()
+	listTop, listHeight, detailTop, detailHeight := b.layout()
+	inverse := tcell.StyleDefault.Reverse(true)
+
+	streamNames := "all streams"
+	if len(b.opts.streamIds) > 0 {
+		streamNames = strings.Join(resolveStreamTitles(b.opts.streamIds, b.streams), ",")
+	}
+	query := b.opts.query
+	if len(query) == 0 {
+		query = "*"
+	}
+	header := fmt.Sprintf(" %s │ %s │ %s │ %d messages", query, streamNames, b.rangeLabel, len(b.visible))
+	if len(b.filter) > 0 {
+		header += " │ filter: " + b.filter
+	}
+	if b.tailing {
+		header += " │ LIVE"
+	}
+	if b.loading {
+		header += " │ loading…"
+	}
+	fillLine(s, 0, width, inverse)
+	drawText(s, 0, 0, width, []styledText{{header, inverse}})
+
+	if b.mode == uiStreams {
+		b.drawStreams(listTop, listHeight, width)
+	} else {
+		for i := 0; i < listHeight && b.top+i < len(b.visible); i++ {
+			row := b.top + i
+			style := tcell.StyleDefault
+			if row == b.selected {
+				style = style.Reverse(true)
+				fillLine(s, listTop+i, width, style)
+			}
+			drawText(s, 0, listTop+i, width, parseANSI(b.entries[b.visible[row]].text, style))
+		}
+	}
+
+	dividerStyle := tcell.StyleDefault.Dim(true)
+	if b.focusFields {
+		dividerStyle = tcell.StyleDefault.Bold(true)
+	}
+	divider := "── Fields " + strings.Repeat("─", width)
+	drawText(s, 0, detailTop-1, width, []styledText{{divider, dividerStyle}})
+	lines := b.detailLines()
+	for i := 0; i < detailHeight && b.detailTop+i < len(lines); i++ {
+		drawText(s, 0, detailTop+i, width, []styledText{{lines[b.detailTop+i], tcell.StyleDefault}})
+	}
+
+	bottom := height - 1
+	switch b.mode {
+	case uiQuery, uiFilter:
+		prompt := "query: "
+		if b.mode == uiFilter {
+			prompt = "/"
+		}
+		x := drawText(s, 0, bottom, width, []styledText{{prompt + string(b.input[:b.cursor]), tcell.StyleDefault}})
+		s.ShowCursor(x, bottom)
+		drawText(s, x, bottom, width, []styledText{{string(b.input[b.cursor:]), tcell.StyleDefault}})
+	case uiStreams:
+		s.HideCursor()
+		drawText(s, 0, bottom, width, []styledText{{"space pick  enter search  esc cancel", dividerStyle}})
+	default:
+		s.HideCursor()
+		if len(b.status) > 0 {
+			drawText(s, 0, bottom, width, []styledText{{b.status, tcell.StyleDefault.Bold(true)}})
+		} else {
+			drawText(s, 0, bottom, width, []styledText{{uiHelp, tcell.StyleDefault.Dim(true)}})
+		}
+	}
+	s.Show()
+}
+
+// Draw the stream picker over the message list.
+func (b *browser) drawStreams(top int, height int, width int) {
+	if b.streamCursor < b.streamTop {
+		b.streamTop = b.streamCursor
+	}
+	if b.streamCursor >= b.streamTop+height {
+		b.streamTop = b.streamCursor - height + 1
+	}
+	for i := 0; i < height && b.streamTop+i < len(b.streamList); i++ {
+		stream := b.streamList[b.streamTop+i]
+		mark := "[ ] "
+		if b.streamChoice[stream["id"]] {
+			mark = "[x] "
+		}
+		style := tcell.StyleDefault
+		if b.streamTop+i == b.streamCursor {
+			style = style.Reverse(true)
+		}
+		drawText(b.screen, 0, top+i, width, []styledText{{mark + stream["title"], style}})
+	}
+}
+
+// Look up the titles of stream ids, keeping the id of unknown streams.
+func resolveStreamTitles(ids []string, streams map[string]map[string]string) []string {
+	var titles []string
+	for _, s := range resolveStreams(ids, streams) {
+		titles = append(titles, s.Title)
+	}
+	return titles
+}
+
+func fillLine(s tcell.Screen, y int, width int, style tcell.Style) {
+	for x := 0; x < width; x++ {
+		s.SetContent(x, y, ' ', nil, style)
+	}
+}
+
+// Draw styled text on a line, cut at the width of the screen. Returns the column after the text.
+func drawText(s tcell.Screen, x int, y int, width int, parts []styledText) int {
+	for _, part := range parts {
+		for _, r := range strings.Replace(part.text, "\t", "    ", -1) {
+			w := runewidth.RuneWidth(r)
+			if w == 0 {
+				continue
+			}
+			if x+w > width {
+				return x
+			}
+			s.SetContent(x, y, r, nil, part.style)
+			x += w
+		}
+	}
+	return x
+}
+
+// Remove the ANSI escape sequences from text.
+func plainText(text string) string {
+	if !strings.Contains(text, "\x1b") {
+		return text
+	}
+	var plain strings.Builder
+	for _, part := range parseANSI(text, tcell.StyleDefault) {
+		plain.WriteString(part.text)
+	}
+	return plain.String()
+}
+
+// Split text colored by ANSI escape sequences, e.g., rows rendered by the format templates, into styled runs. The
+// SGR attributes and colors (16, 256 and 24-bit) are applied on top of the base style, other sequences are dropped.
+func parseANSI(text string, base tcell.Style) (parts []styledText) {
+	style := base
+	for len(text) > 0 {
+		esc := strings.IndexByte(text, '\x1b')
+		if esc < 0 {
+			parts = append(parts, styledText{text, style})
+			break
+		}
+		if esc > 0 {
+			parts = append(parts, styledText{text[:esc], style})
+		}
+		text = text[esc+1:]
+		if !strings.HasPrefix(text, "[") {
+			continue
+		}
+		end := strings.IndexFunc(text[1:], func(r rune) bool {
+			return r >= '@' && r <= '~'
+		})
+		if end < 0 {
+			break
+		}
+		params, final := text[1:end+1], text[end+1]
+		text = text[end+2:]
+		if final == 'm' {
+			style = applySGR(style, base, params)
+		}
+	}
+	return parts
+}
+
+// Apply the parameters of an SGR escape sequence, e.g., "1;31" or "38;5;208", to a style.
+func applySGR(style tcell.Style, base tcell.Style, params string) tcell.Style {
+	codes := strings.Split(params, ";")
+	for i := 0; i < len(codes); i++ {
+		code, _ := strconv.Atoi(codes[i])
+		switch {
+		case code == 0:
+			style = base
+		case code == 1:
+			style = style.Bold(true)
+		case code == 2:
+			style = style.Dim(true)
+		case code == 3:
+			style = style.Italic(true)
+		case code == 4:
+			style = style.Underline(true)
+		case code == 22:
+			style = style.Bold(false).Dim(false)
+		case code == 23:
+			style = style.Italic(false)
+		case code == 24:
+			style = style.Underline(false)
+		case code >= 30 && code <= 37:
+			style = style.Foreground(tcell.PaletteColor(code - 30))
+		case code >= 90 && code <= 97:
+			style = style.Foreground(tcell.PaletteColor(code - 90 + 8))
+		case code == 39:
+			fg, _, _ := base.Decompose()
+			style = style.Foreground(fg)
+		case code >= 40 && code <= 47:
+			style = style.Background(tcell.PaletteColor(code - 40))
+		case code >= 100 && code <= 107:
+			style = style.Background(tcell.PaletteColor(code - 100 + 8))
+		case code == 49:
+			_, bg, _ := base.Decompose()
+			style = style.Background(bg)
+		case code == 38 || code == 48:
+			var color tcell.Color
+			if i+2 < len(codes) && codes[i+1] == "5" {
+				n, _ := strconv.Atoi(codes[i+2])
+				color = tcell.PaletteColor(n)
+				i += 2
+			} else if i+4 < len(codes) && codes[i+1] == "2" {
+				r, _ := strconv.Atoi(codes[i+2])
+				g, _ := strconv.Atoi(codes[i+3])
+				b, _ := strconv.Atoi(codes[i+4])
+				color = tcell.NewRGBColor(int32(r), int32(g), int32(b))
+				i += 4
+			} else {
+				continue
+			}
+			if code == 38 {
+				style = style.Foreground(color)
+			} else {
+				style = style.Background(color)
+			}
+		}
+	}
+	return style
+}
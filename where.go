@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// whereExpr is a parsed --where expression, evaluated against the fields of an adjusted message.
+type whereExpr interface {
+	eval(fields map[string]string) bool
+}
+
+type andExpr struct{ left, right whereExpr }
+type orExpr struct{ left, right whereExpr }
+type notExpr struct{ expr whereExpr }
+type existsExpr struct{ field string }
+
+// truthExpr is a field used on its own, e.g., 'retry'. It's true when present and not empty, "false" or "0".
+type truthExpr struct{ field string }
+
+// A field name or literal used in a comparison.
+type whereOperand struct {
+	field   string
+	literal string
+	isField bool
+}
+
+type compareExpr struct {
+	left  whereOperand
+	op    string
+	right whereOperand
+	regex *regexp.Regexp
+}
+
+func (e *andExpr) eval(fields map[string]string) bool {
+	return e.left.eval(fields) && e.right.eval(fields)
+}
+
+func (e *orExpr) eval(fields map[string]string) bool {
+	return e.left.eval(fields) || e.right.eval(fields)
+}
+
+func (e *notExpr) eval(fields map[string]string) bool {
+	return !e.expr.eval(fields)
+}
+
+func (e *existsExpr) eval(fields map[string]string) bool {
+	_, ok := fields[e.field]
+	return ok
+}
+
+func (e *truthExpr) eval(fields map[string]string) bool {
+	v := fields[e.field]
+	return len(v) > 0 && v != "false" && v != "0"
+}
+
+func (o whereOperand) value(fields map[string]string) (string, bool) {
+	if !o.isField {
+		return o.literal, true
+	}
+	v, ok := fields[o.field]
+	return v, ok
+}
+
+// Compare two values. Missing fields make every comparison false, except the negated ones (!= and !~). Values that
+// are both numbers are compared as numbers, anything else as text.
+func (e *compareExpr) eval(fields map[string]string) bool {
+	left, leftOk := e.left.value(fields)
+	right, rightOk := e.right.value(fields)
+	if !leftOk || !rightOk {
+		return e.op == "!=" || e.op == "!~"
+	}
+
+	switch e.op {
+	case "=~":
+		return e.regex.MatchString(left)
+	case "!~":
+		return !e.regex.MatchString(left)
+	}
+
+	var cmp int
+	leftNum, err1 := strconv.ParseFloat(left, 64)
+	rightNum, err2 := strconv.ParseFloat(right, 64)
+	if err1 == nil && err2 == nil {
+		switch {
+		case leftNum < rightNum:
+			cmp = -1
+		case leftNum > rightNum:
+			cmp = 1
+		}
+	} else {
+		cmp = strings.Compare(left, right)
+	}
+
+	switch e.op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// Check whether a message passes every --where expression.
+func matchesWhere(filters []whereExpr, msg logMessage) bool {
+	for _, f := range filters {
+		if !f.eval(msg.fields) {
+			return false
+		}
+	}
+	return true
+}
+
+// Keep the messages that pass every --where expression. The messages must already be adjusted.
+func filterWhere(filters []whereExpr, messages []logMessage) []logMessage {
+	if len(filters) == 0 {
+		return messages
+	}
+	var result []logMessage
+	for _, msg := range messages {
+		if matchesWhere(filters, msg) {
+			result = append(result, msg)
+		}
+	}
+	return result
+}
+
+// Parse the --where expressions.
+func parseWhereList(expressions []string) (filters []whereExpr, err error) {
+	for _, text := range expressions {
+		expr, err := parseWhere(text)
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %s", text, err.Error())
+		}
+		filters = append(filters, expr)
+	}
+	return filters, nil
+}
+
+// Parse a --where expression, e.g., 'loglevel == "ERROR" and not _message_text =~ "timeout"'.
+//
+//	expr       = and { ("or" | "||") and }
+//	and        = unary { ("and" | "&&") unary }
+//	unary      = ("not" | "!") unary | "(" expr ")" | "exists" field | comparison
+//	comparison = operand [ ("==" | "!=" | "=~" | "!~" | "<" | "<=" | ">" | ">=") operand ]
+//
+// Operands are field names, quoted strings or numbers. The right side of =~ and !~ is a regex in a quoted string.
+func parseWhere(text string) (whereExpr, error) {
+	tokens, err := tokenizeWhere(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &whereParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected '%s'", p.tokens[p.pos].text)
+	}
+	return expr, nil
+}
+
+// Kinds of --where tokens.
+const (
+	identToken = iota
+	stringToken
+	numberToken
+	operatorToken
+)
+
+type whereToken struct {
+	kind int
+	text string
+}
+
+var whereOperators = []string{"==", "!=", "=~", "!~", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")"}
+
+// Split an expression into tokens.
+func tokenizeWhere(text string) (tokens []whereToken, err error) {
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			var value strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+				value.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, whereToken{kind: stringToken, text: value.String()})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, whereToken{kind: numberToken, text: string(runes[i:j])})
+			i = j
+		case unicode.IsLetter(r) || r == '_' || r == '@':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || strings.ContainsRune("_.-@", runes[j])) {
+				j++
+			}
+			tokens = append(tokens, whereToken{kind: identToken, text: string(runes[i:j])})
+			i = j
+		default:
+			var op string
+			for _, candidate := range whereOperators {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					op = candidate
+					break
+				}
+			}
+			if len(op) == 0 {
+				return nil, fmt.Errorf("unexpected '%c'", r)
+			}
+			tokens = append(tokens, whereToken{kind: operatorToken, text: op})
+			i += len([]rune(op))
+		}
+	}
+	return tokens, nil
+}
+
+type whereParser struct {
+	tokens []whereToken
+	pos    int
+}
+
+// Check whether the next token is one of the given operators or keywords, and consume it if so.
+func (p *whereParser) accept(words ...string) bool {
+	if p.pos >= len(p.tokens) {
+		return false
+	}
+	t := p.tokens[p.pos]
+	if t.kind != operatorToken && t.kind != identToken {
+		return false
+	}
+	for _, w := range words {
+		if t.text == w || (t.kind == identToken && strings.EqualFold(t.text, w)) {
+			p.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *whereParser) parseOr() (whereExpr, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("or", "||") {
+		var right whereExpr
+		if right, err = p.parseAnd(); err == nil {
+			left = &orExpr{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *whereParser) parseAnd() (whereExpr, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.accept("and", "&&") {
+		var right whereExpr
+		if right, err = p.parseUnary(); err == nil {
+			left = &andExpr{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *whereParser) parseUnary() (whereExpr, error) {
+	if p.accept("not", "!") {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{expr}, nil
+	}
+	if p.accept("(") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("missing ')'")
+		}
+		return expr, nil
+	}
+	if p.accept("exists") {
+		parens := p.accept("(")
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != identToken {
+			return nil, fmt.Errorf("exists requires a field name")
+		}
+		field := p.tokens[p.pos].text
+		p.pos++
+		if parens && !p.accept(")") {
+			return nil, fmt.Errorf("missing ')'")
+		}
+		return &existsExpr{field}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *whereParser) parseComparison() (whereExpr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != operatorToken {
+		if !left.isField {
+			return nil, fmt.Errorf("expected a comparison after '%s'", left.literal)
+		}
+		return &truthExpr{left.field}, nil
+	}
+
+	op := p.tokens[p.pos].text
+	switch op {
+	case "==", "!=", "=~", "!~", "<", "<=", ">", ">=":
+		p.pos++
+	default:
+		if !left.isField {
+			return nil, fmt.Errorf("expected a comparison after '%s'", left.literal)
+		}
+		return &truthExpr{left.field}, nil
+	}
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	expr := &compareExpr{left: left, op: op, right: right}
+	if op == "=~" || op == "!~" {
+		if right.isField {
+			return nil, fmt.Errorf("the regex after %s must be a quoted string", op)
+		}
+		if expr.regex, err = regexp.Compile(right.literal); err != nil {
+			return nil, err
+		}
+	}
+	return expr, nil
+}
+
+func (p *whereParser) parseOperand() (whereOperand, error) {
+	if p.pos >= len(p.tokens) {
+		return whereOperand{}, fmt.Errorf("unexpected end of expression")
+	}
+	t := p.tokens[p.pos]
+	switch t.kind {
+	case identToken:
+		switch strings.ToLower(t.text) {
+		case "and", "or", "not", "exists":
+			return whereOperand{}, fmt.Errorf("unexpected '%s'", t.text)
+		}
+		p.pos++
+		return whereOperand{field: t.text, isField: true}, nil
+	case stringToken, numberToken:
+		p.pos++
+		return whereOperand{literal: t.text}, nil
+	}
+	return whereOperand{}, fmt.Errorf("unexpected '%s'", t.text)
+}